@@ -0,0 +1,251 @@
+// alias.go
+// user-defined commands
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// MaxAliasDepth is the number of aliases which may expand into each other
+// before execution fails.  It stops aliases which expand into themselves.
+var MaxAliasDepth = 16
+
+// An Alias is a command defined by the user which expands into one or more
+// other commands.  Positional parameters "$1" through "$9" in the expansion
+// are replaced with the alias's arguments and "$@" is replaced with all of
+// them.
+type Alias struct {
+	Name string
+	Text string // commands separated by semicolons
+	lib  *Lib
+	cmds [][]string
+}
+
+var _ JQShellCommand = (*Alias)(nil)
+
+// NewAlias returns an alias that expands to the commands in text.  Commands
+// are separated by semicolons and use the syntax of colon commands, with an
+// optional leading colon.
+func NewAlias(name, text string) (*Alias, error) {
+	a := &Alias{Name: name, Text: text}
+	for _, line := range splitScript(text) {
+		cmd, err := tokenizeCommand(strings.TrimPrefix(line, ":"), 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(cmd) > 0 {
+			a.cmds = append(a.cmds, cmd)
+		}
+	}
+	if len(a.cmds) == 0 {
+		return nil, fmt.Errorf("empty alias")
+	}
+	return a, nil
+}
+
+// Expand returns the commands a runs when given args.
+func (a *Alias) Expand(args []string) [][]string {
+	cmds := make([][]string, len(a.cmds))
+	for i, cmd := range a.cmds {
+		var exp []string
+		for _, word := range cmd {
+			if word == "$@" {
+				exp = append(exp, args...)
+				continue
+			}
+			exp = append(exp, expandParams(word, args))
+		}
+		cmds[i] = exp
+	}
+	return cmds
+}
+
+// expandParams replaces positional parameters in word.  Parameters without a
+// corresponding argument are replaced with an empty string.  A '$' followed by
+// anything else is left alone so jq variables are not affected.
+func expandParams(word string, args []string) string {
+	if !strings.Contains(word, "$") {
+		return word
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(word); i++ {
+		if word[i] != '$' || i+1 == len(word) {
+			buf.WriteByte(word[i])
+			continue
+		}
+		c := word[i+1]
+		switch {
+		case c == '@':
+			buf.WriteString(strings.Join(args, " "))
+		case c >= '1' && c <= '9':
+			n := int(c - '0')
+			if n <= len(args) {
+				buf.WriteString(args[n-1])
+			}
+		default:
+			buf.WriteByte(word[i])
+			continue
+		}
+		i++
+	}
+	return buf.String()
+}
+
+// ExecuteShellCommand runs the commands a expands into, stopping at the first
+// one that fails.
+func (a *Alias) ExecuteShellCommand(jq *JQShell, flags *CmdFlags) error {
+	if len(flags.args) == 1 && isHelpFlag(flags.args[0]) {
+		flags.About(fmt.Sprintf("User-defined alias for %q.", a.Text))
+		flags.ArgSet("[arg]", "...")
+		flags.ArgDoc("arg", "substituted for $1 through $9, or $@, in the alias")
+		flags.Parse(nil)
+		return nil
+	}
+	lib := a.lib
+	if lib.depth >= MaxAliasDepth {
+		return fmt.Errorf("aliases nested too deeply")
+	}
+	lib.depth++
+	defer func() { lib.depth-- }()
+	for _, cmd := range a.Expand(flags.args) {
+		err := lib.exec(nil, jq, cmd[0], cmd[1:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isHelpFlag(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--h", "--help":
+		return true
+	}
+	return false
+}
+
+// setAlias registers a, replacing any alias with the same name.  Commands and
+// help topics cannot be replaced.  The caller must hold lib.mut.
+func (lib *Lib) setAlias(a *Alias) error {
+	if _, ok := lib.cmds[a.Name].(*Alias); !ok {
+		err := lib.taken(a.Name)
+		if err != nil {
+			return err
+		}
+	}
+	a.lib = lib
+	lib.cmds[a.Name] = a
+	return nil
+}
+
+// removeAlias unregisters the named alias.  The caller must hold lib.mut.
+func (lib *Lib) removeAlias(name string) error {
+	if _, ok := lib.cmds[name].(*Alias); !ok {
+		return fmt.Errorf("%q is not an alias", name)
+	}
+	delete(lib.cmds, name)
+	return nil
+}
+
+// aliases returns the registered aliases sorted by name.  The caller must hold
+// lib.mut.
+func (lib *Lib) aliases() []*Alias {
+	var aliases []*Alias
+	for _, cmd := range lib.cmds {
+		if a, ok := cmd.(*Alias); ok {
+			aliases = append(aliases, a)
+		}
+	}
+	sort.Sort(aliasesByName(aliases))
+	return aliases
+}
+
+type aliasesByName []*Alias
+
+func (s aliasesByName) Len() int           { return len(s) }
+func (s aliasesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s aliasesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func cmdAlias(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command alias defines a command which runs other commands.")
+	flags.ArgSet("[name]", "[command]", "...")
+	flags.ArgDoc("name", "the name of the alias")
+	flags.ArgDoc("command", "commands separated by semicolons, without colons")
+	flags.Docs(
+		"In the commands \"$1\" through \"$9\" are replaced with the arguments given",
+		"to the alias and \"$@\" is replaced with all of them.  Without",
+		"commands the named alias is printed.  Without a name all aliases are",
+		"printed.",
+		"",
+		"\t> :alias ids push .[].id",
+		"\t> :alias top +push -q 'limit($1; .[])'; write",
+		"\t> :top 5",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	args := flags.Args()
+	switch len(args) {
+	case 0:
+		tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+		for _, a := range jq.lib.aliases() {
+			fmt.Fprintf(tw, "%s\t%s\n", a.Name, a.Text)
+		}
+		return tw.Flush()
+	case 1:
+		a, ok := jq.lib.cmds[args[0]].(*Alias)
+		if !ok {
+			return fmt.Errorf("unknown alias %q", args[0])
+		}
+		fmt.Println(a.Text)
+		return nil
+	}
+	words := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		words[i] = strings.TrimSuffix(escapeWord(arg+" "), " ")
+	}
+	text := strings.Join(words, " ")
+	if len(args) == 2 {
+		// a single argument is taken as the alias text, which is how
+		// arguments beginning with '+' are given.
+		text = args[1]
+	}
+	a, err := NewAlias(args[0], text)
+	if err != nil {
+		return err
+	}
+	return jq.lib.setAlias(a)
+}
+
+func cmdUnalias(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command unalias removes aliases.")
+	flags.ArgSet("alias", "...")
+	flags.ArgDoc("alias", "the name of an alias")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects at least one alias")
+	}
+	for _, name := range flags.Args() {
+		err := jq.lib.removeAlias(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAliasExpand(t *testing.T) {
+	cmd := func(strs ...string) []string { return strs }
+	for i, test := range []struct {
+		text string
+		args []string
+		cmds [][]string
+	}{
+		{"push .a", nil, [][]string{cmd("push", ".a")}},
+		{":push -q .a; write", nil, [][]string{cmd("push", "-q", ".a"), cmd("write")}},
+		{"push '.[$1]'", cmd("0"), [][]string{cmd("push", ".[0]")}},
+		{"push $@", cmd(".a", ".b"), [][]string{cmd("push", ".a", ".b")}},
+		{"push '[$@]'", cmd("1", "2"), [][]string{cmd("push", "[1 2]")}},
+		{"push '$2$x$'", cmd("1"), [][]string{cmd("push", "$x$")}},
+		{"push 'limit($1; .[])'; write $2", cmd("3", "out.json"), [][]string{cmd("push", "limit(3; .[])"), cmd("write", "out.json")}},
+	} {
+		a, err := NewAlias("test", test.text)
+		if err != nil {
+			t.Errorf("test %d (%q): %v", i, test.text, err)
+			continue
+		}
+		cmds := a.Expand(test.args)
+		if !reflect.DeepEqual(cmds, test.cmds) {
+			t.Errorf("test %d (%q %q): got %q (expect %q)", i, test.text, test.args, cmds, test.cmds)
+		}
+	}
+	_, err := NewAlias("test", " ; ")
+	if err == nil {
+		t.Errorf("empty alias accepted")
+	}
+}
+
+func TestLibAlias(t *testing.T) {
+	var ran [][]string
+	lib := Library(nil)
+	lib.Register("record", JQShellCommandFunc(func(jq *JQShell, flags *CmdFlags) error {
+		ran = append(ran, flags.args)
+		return nil
+	}))
+	for _, alias := range []struct{ name, text string }{
+		{"one", "record a $1"},
+		{"two", "one b; record $@"},
+		{"loop", "loop"},
+	} {
+		a, err := NewAlias(alias.name, alias.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = lib.setAlias(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, _ := NewAlias("record", "one")
+	if lib.setAlias(a) == nil {
+		t.Errorf("alias replaced a command")
+	}
+
+	err := lib.Execute(nil, "two", []string{"x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]string{{"a", "b"}, {"x", "y"}}
+	if !reflect.DeepEqual(ran, expect) {
+		t.Errorf("ran %q (expect %q)", ran, expect)
+	}
+	err = lib.Execute(nil, "loop", nil)
+	if err == nil {
+		t.Errorf("recursive alias did not fail")
+	}
+	if lib.depth != 0 {
+		t.Errorf("depth %d after execution", lib.depth)
+	}
+}
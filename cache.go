@@ -0,0 +1,291 @@
+// cache.go
+// the output of each level of the filter stack, kept to avoid recomputation
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Cache modes for the cache option.
+const (
+	CacheOff    = "off"
+	CacheMemory = "memory"
+	CacheFile   = "file"
+)
+
+var errCacheFull = fmt.Errorf("cache size exceeded")
+
+// A StackCache holds the output of the filter stack at each depth.  The
+// output of a level is computed by applying its filter to the output of the
+// level below it, so pushing a filter only applies that filter and popping a
+// filter only reformats output that is already cached.
+//
+// Splitting the stack this way is only correct when the filters below a level
+// bind no variables, functions or labels and the filters above it do not read
+// input (see splitSafe).  Otherwise a level is computed from the input with
+// the whole program it corresponds to.
+//
+// Levels are computed for a particular input and set of options.  The cache
+// is cleared when either changes.
+type StackCache struct {
+	key    string
+	levels []*cacheLevel
+	size   int64 // the number of bytes held in memory
+}
+
+type cacheLevel struct {
+	filter string // the program producing the level from the input
+	data   []byte
+	path   string // a temporary file holding the output instead of data
+}
+
+// Len returns the number of levels in the cache.
+func (c *StackCache) Len() int {
+	return len(c.levels)
+}
+
+// Clear removes all levels from the cache.
+func (c *StackCache) Clear() {
+	c.truncate(0)
+	c.key = ""
+}
+
+// truncate removes levels above depth n.
+func (c *StackCache) truncate(n int) {
+	for _, level := range c.levels[n:] {
+		c.size -= int64(len(level.data))
+		level.remove()
+	}
+	for i := n; i < len(c.levels); i++ {
+		c.levels[i] = nil
+	}
+	c.levels = c.levels[:n]
+}
+
+// match clears the cache if it was computed with a different key, and then
+// removes the levels which do not correspond to filters.
+func (c *StackCache) match(key string, filters []Filter) {
+	if key != c.key {
+		c.Clear()
+		c.key = key
+	}
+	n := 0
+	for n < len(c.levels) && n < len(filters) && c.levels[n].filter == joinFilters(filters[:n+1]) {
+		n++
+	}
+	c.truncate(n)
+}
+
+func (l *cacheLevel) open() (io.ReadCloser, error) {
+	if l.path != "" {
+		return os.Open(l.path)
+	}
+	return ioutil.NopCloser(bytes.NewReader(l.data)), nil
+}
+
+func (l *cacheLevel) remove() {
+	if l.path != "" {
+		os.Remove(l.path)
+	}
+}
+
+// cappedBuffer is a bytes.Buffer which refuses writes beyond max bytes.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.max {
+		return 0, errCacheFull
+	}
+	return b.Buffer.Write(p)
+}
+
+// caching returns true if filter output should be computed using jq.cache.
+// Input produced by a command each time it is read is never cached.
+func (jq *JQShell) caching() bool {
+	return jq.Options.Cache != CacheOff && jq.filename != "" && jq.Stack.Len() > 0
+}
+
+// cacheKey identifies the input and the options which change the values the
+// filter stack produces.
+func (jq *JQShell) cacheKey() string {
+	opts := jq.Options.filterOptions()
+	return fmt.Sprintf("%s %d %q %q", jq.Options.Cache, jq.inputgen, opts.Args(), opts.Program("."))
+}
+
+// executeCached is like Execute for the filter stack and input of jq.  Levels
+// of the stack missing from the cache are computed and cached first.  The
+// filters above the highest cached level which can be split from the stack
+// are then applied to it, or the whole stack is applied to the input if there
+// is no such level.
+func (jq *JQShell) executeCached(outw, errw io.Writer, stop <-chan struct{}, color bool) (int64, int64, error) {
+	filters := jq.Stack.Filters()
+	jq.cache.match(jq.cacheKey(), filters)
+	for jq.cache.Len() < len(filters) {
+		err := jq.cacheLevel(filters[:jq.cache.Len()+1], stop)
+		if err != nil {
+			break
+		}
+	}
+
+	depth := jq.cache.Len()
+	for depth > 0 && !splitSafe(filters, depth) {
+		depth--
+	}
+	opts := jq.Options
+	if depth > 0 {
+		opts.Stream = false
+		opts.Slurp = false
+		opts.NullInput = false
+	}
+	s := new(JQStack)
+	s.SetFilters(filters[depth:])
+	return jq.executeLevel(depth, outw, errw, stop, color, &opts, s)
+}
+
+// executeLevel applies the filter in s to the input of the filter at index
+// depth in the stack.
+func (jq *JQShell) executeLevel(depth int, outw, errw io.Writer, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	if depth == 0 {
+		return jq.executeInput(outw, errw, stop, color, opts, s)
+	}
+	r, err := jq.cache.levels[depth-1].open()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	return jq.engine.Execute(outw, errw, r, stop, color, opts, s)
+}
+
+// cacheLevel computes the output of filters, which extend the highest level
+// of the cache by one filter, and caches it as a new level.  The last filter
+// is applied to the highest level when the stack can be split there, otherwise
+// all of filters are applied to the input.  Errors from jq are not reported,
+// they are reported when the filter is applied without the cache.
+func (jq *JQShell) cacheLevel(filters []Filter, stop <-chan struct{}) error {
+	depth := jq.cache.Len()
+	if depth > 0 && !splitSafe(filters, depth) {
+		depth = 0
+	}
+
+	// values are written one per line with no formatting.  the options
+	// changing how input is read only apply to the bottom of the stack.
+	opts := jq.Options.filterOptions()
+	opts.Compact = true
+	if depth > 0 {
+		opts.Stream = false
+		opts.Slurp = false
+		opts.NullInput = false
+	}
+	s := new(JQStack)
+	s.SetFilters(filters[depth:])
+
+	level := &cacheLevel{filter: joinFilters(filters)}
+	var w io.Writer
+	var buf *cappedBuffer
+	var f *os.File
+	var err error
+	if jq.Options.Cache == CacheFile {
+		f, err = ioutil.TempFile("", "jqsh-cache-")
+		if err != nil {
+			return err
+		}
+		level.path = f.Name()
+		w = f
+	} else {
+		buf = &cappedBuffer{max: int64(jq.Options.CacheSize)<<20 - jq.cache.size}
+		w = buf
+	}
+	_, _, err = jq.executeLevel(depth, w, ioutil.Discard, stop, false, opts, s)
+	if f != nil {
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	select {
+	case <-stop:
+		err = ErrInterrupted
+	default:
+	}
+	if err != nil {
+		level.remove()
+		return err
+	}
+	if buf != nil {
+		level.data = buf.Bytes()
+		jq.cache.size += int64(len(level.data))
+	}
+	jq.cache.levels = append(jq.cache.levels, level)
+	return nil
+}
+
+// splitSafe returns true if applying filters[n:] to the output of filters[:n]
+// is equivalent to applying filters to the input.  That is not the case when
+// filters[:n] define a variable, function or label which filters[n:] may
+// refer to, or when filters[n:] read input with input or inputs.  Bindings
+// are detected by keyword, so some filters which could be split are not.
+func splitSafe(filters []Filter, n int) bool {
+	for _, f := range filters[:n] {
+		if usesWord(JoinFilter(f), "as", "def", "label") {
+			return false
+		}
+	}
+	for _, f := range filters[n:] {
+		if usesWord(JoinFilter(f), "input", "inputs", "input_filename", "input_line_number") {
+			return false
+		}
+	}
+	return true
+}
+
+// usesWord returns true if filter contains any of words as a keyword or
+// function name.  Field names, variables, comments and strings are ignored,
+// but the text of strings containing interpolations is searched.
+func usesWord(filter string, words ...string) bool {
+	for i := 0; i < len(filter); i++ {
+		c := filter[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(filter) && filter[j] != '"' {
+				if filter[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j > len(filter) {
+				j = len(filter)
+			}
+			if !strings.Contains(filter[i:j], `\(`) {
+				i = j
+			}
+		case c == '#':
+			for i < len(filter) && filter[i] != '\n' {
+				i++
+			}
+		case isWordRune(rune(c)):
+			j := i
+			for j < len(filter) && isWordRune(rune(filter[j])) {
+				j++
+			}
+			if i == 0 || (filter[i-1] != '.' && filter[i-1] != '$') {
+				for _, w := range words {
+					if filter[i:j] == w {
+						return true
+					}
+				}
+			}
+			i = j - 1
+		}
+	}
+	return false
+}
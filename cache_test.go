@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExecuteCached(t *testing.T) {
+	jqbin, err := LocateJQ("")
+	if err != nil {
+		t.Skipf("unable to find jq in PATH: %v", err)
+	}
+	for _, mode := range []string{CacheMemory, CacheFile} {
+		jq := &JQShell{
+			Stack:   new(JQStack),
+			Options: DefaultJQOptions(),
+			engine:  &ProcessEngine{Bin: jqbin},
+		}
+		jq.SetInputFile("example.json", false)
+		jq.Options.Cache = mode
+
+		check := func(depth int) {
+			var uncached, cached bytes.Buffer
+			r, err := jq.Input()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = jq.engine.Execute(&uncached, &uncached, r, nil, false, &jq.Options, jq.Stack)
+			r.Close()
+			if err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+			_, _, err = jq.executeCached(&cached, &cached, nil, false)
+			if err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+			if cached.String() != uncached.String() {
+				t.Errorf("%s %q: got %q (expect %q)", mode, JoinFilter(jq.Stack), cached.String(), uncached.String())
+			}
+			if jq.cache.Len() != depth {
+				t.Errorf("%s %q: %d levels cached (expect %d)", mode, JoinFilter(jq.Stack), jq.cache.Len(), depth)
+			}
+		}
+
+		jq.Stack.Push(FilterString(".items[]"))
+		check(1)
+		jq.Stack.Push(FilterString(".name"))
+		check(2)
+		jq.Stack.Pop(1)
+		jq.Stack.Push(FilterString("{type}"))
+		check(2)
+		jq.Options.Slurp = true
+		jq.Stack.PopAll()
+		jq.Stack.Push(FilterString(".[0]"))
+		check(1)
+		jq.Stack.Push(FilterString(".items"))
+		check(2)
+
+		// a filter that fails is applied without the cache.
+		jq.Stack.Push(FilterString(".foo"))
+		var out bytes.Buffer
+		_, _, err := jq.executeCached(&out, &out, nil, false)
+		if err == nil {
+			t.Errorf("%s: expected an error", mode)
+		}
+		if jq.cache.Len() != 2 {
+			t.Errorf("%s: %d levels cached (expect 2)", mode, jq.cache.Len())
+		}
+		jq.ClearInput()
+		if jq.cache.Len() != 0 {
+			t.Errorf("%s: %d levels cached after input cleared", mode, jq.cache.Len())
+		}
+	}
+}
+
+func TestExecuteCachedSplit(t *testing.T) {
+	jqbin, err := LocateJQ("")
+	if err != nil {
+		t.Skipf("unable to find jq in PATH: %v", err)
+	}
+	f, err := ioutil.TempFile("", "jqsh-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, `{"a":1} {"a":2} {"a":3} {"a":4}`)
+	f.Close()
+
+	for i, test := range []struct {
+		file    string
+		filters []string
+	}{
+		{"example.json", []string{". as $root | .items[0]", "$root | keys"}},
+		{"example.json", []string{"def f: .name; .items[]", "f"}},
+		{"example.json", []string{"label $out | .items[]", "., break $out"}},
+		{"example.json", []string{".items[]", `"\(.name) is \($__loc__.line)"`}},
+		{f.Name(), []string{".a", "[., input.a]"}},
+		{f.Name(), []string{".a", "[inputs]"}},
+		{f.Name(), []string{"[., input]", ".[1]", ". as $x | .a", "$x"}},
+	} {
+		for _, mode := range []string{CacheMemory, CacheFile} {
+			jq := &JQShell{
+				Stack:   new(JQStack),
+				Options: DefaultJQOptions(),
+				engine:  &ProcessEngine{Bin: jqbin},
+			}
+			jq.SetInputFile(test.file, false)
+			jq.Options.Cache = mode
+			for _, filter := range test.filters {
+				jq.Stack.Push(FilterString(filter))
+				var uncached, cached bytes.Buffer
+				r, err := jq.Input()
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _, uerr := jq.engine.Execute(&uncached, &uncached, r, nil, false, &jq.Options, jq.Stack)
+				r.Close()
+				_, _, cerr := jq.executeCached(&cached, &cached, nil, false)
+				if (cerr == nil) != (uerr == nil) {
+					t.Errorf("test %d %s %q: got error %v (expect %v)", i, mode, JoinFilter(jq.Stack), cerr, uerr)
+				}
+				if cached.String() != uncached.String() {
+					t.Errorf("test %d %s %q: got %q (expect %q)", i, mode, JoinFilter(jq.Stack), cached.String(), uncached.String())
+				}
+			}
+			jq.cache.Clear()
+		}
+	}
+}
+
+func TestSplitSafe(t *testing.T) {
+	for i, test := range []struct {
+		filters []string
+		n       int
+		expect  bool
+	}{
+		{[]string{".items[]", ".name"}, 1, true},
+		{[]string{".as | .def", "$label"}, 1, true},
+		{[]string{`select(.x == "as")`, ".input"}, 1, true},
+		{[]string{".a # as", ".b"}, 1, true},
+		{[]string{". as $x | .a", "$x"}, 1, false},
+		{[]string{". as $x | .a", "$x"}, 0, true},
+		{[]string{"def f: .; .", "f"}, 1, false},
+		{[]string{"label $out | .[]", "break $out"}, 1, false},
+		{[]string{".a", "[., input]"}, 1, false},
+		{[]string{".a", "[inputs]"}, 1, false},
+		{[]string{".a", `"\(input)"`}, 1, false},
+		{[]string{"first(inputs)", ".a"}, 1, true},
+		{[]string{".a", "[., input]"}, 2, true},
+	} {
+		var filters []Filter
+		for _, f := range test.filters {
+			filters = append(filters, FilterString(f))
+		}
+		if splitSafe(filters, test.n) != test.expect {
+			t.Errorf("test %d (%q at %d): expected %v", i, test.filters, test.n, test.expect)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/doc"
 	"io"
@@ -32,6 +33,7 @@ type Lib struct {
 	topics map[string][]string
 	cmds   map[string]JQShellCommand
 	docs   DocOpt
+	depth  int // the number of aliases being expanded
 }
 
 func Library(docs *DocOpt) *Lib {
@@ -228,10 +230,13 @@ func cmdScript(jq *JQShell, flags *CmdFlags) error {
 	script = append(script, "#!/usr/bin/env sh")
 	script = append(script, "")
 
-	f := JoinFilter(jq.Stack)
+	f := jq.Options.Program(JoinFilter(jq.Stack))
 	fesc := shellEscape(f, "'", "\\'")
 	bin := "jq"
 	cmd := []string{bin}
+	for _, arg := range jq.Options.bindVars(f).Args() {
+		cmd = append(cmd, shellQuote(arg))
+	}
 	cmd = append(cmd, fesc)
 	cmd = append(cmd, `"${@}"`)
 	script = append(script, strings.Join(cmd, " "))
@@ -244,6 +249,17 @@ func shellEscape(s, q, qesc string) string {
 	return q + strings.Replace(s, q, qesc, -1) + q
 }
 
+// shellQuote returns s quoted for a POSIX shell.  Words that do not need
+// quoting are returned unchanged.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(c rune) bool {
+		return !(isWordRune(c) || strings.ContainsRune("-./=:,+@%", c))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
 func cmdFilter(jq *JQShell, flags *CmdFlags) error {
 	flags.About("Command filter prints the current filter stack.")
 	jqsyntax := flags.Bool("jq", false, "print the filter with jq syntax")
@@ -273,13 +289,21 @@ func cmdFilter(jq *JQShell, flags *CmdFlags) error {
 		fmt.Println(filter)
 		return nil
 	}
-	filters := jq.Stack.JQFilter()
+	filters := jq.Stack.Filters()
 	if len(filters) == 0 {
 		fmt.Fprintln(os.Stderr, "no filter")
 		return nil
 	}
+	events := jq.streamEvents()
+	if events > 0 {
+		fmt.Fprintln(os.Stderr, "input is streamed -- filters marked with * see [path, leaf] events")
+	}
 	for i, piece := range filters {
-		fmt.Printf("[%02d] %v\n", i, piece)
+		mark := " "
+		if i < events {
+			mark = "*"
+		}
+		fmt.Printf("[%02d]%s%v\n", i, mark, JoinFilter(piece))
 	}
 	return nil
 }
@@ -326,45 +350,131 @@ func cmdPush(jq *JQShell, flags *CmdFlags) error {
 		return err
 	}
 	args := flags.Args()
-	for _, arg := range args {
-		if arg == "" {
-			continue
+	err = jq.modifyStack(func(s *JQStack) error {
+		for _, arg := range args {
+			if arg == "" {
+				continue
+			}
+			s.Push(FilterString(arg))
 		}
-		jq.Stack.Push(FilterString(arg))
-	}
-	err = testFilter(jq)
+		return nil
+	})
 	if err != nil {
-		jq.Stack.Pop(1)
 		return err
 	}
 	if !*quiet {
-		return cmdWrite(jq, Flags("write", []string{}))
+		return jq.writeImplicit()
 	}
 	return nil
 }
 
-var testFilterTimeout = 10 * time.Second
+// modifyStack applies fn to the filter stack and tests the resulting filter.
+// If fn returns an error or jq rejects the filter the stack is restored to
+// its previous state.
+func (jq *JQShell) modifyStack(fn func(s *JQStack) error) error {
+	return jq.changeStack(func(s *JQStack) error {
+		err := fn(s)
+		if err != nil {
+			return err
+		}
+		return testFilter(jq)
+	})
+}
 
+// changeStack applies fn to the filter stack.  If fn returns an error the
+// stack is restored to its previous state.  Otherwise the previous state is
+// recorded so the change can be undone.
+func (jq *JQShell) changeStack(fn func(s *JQStack) error) error {
+	saved := jq.Stack.Filters()
+	err := fn(jq.Stack)
+	if err != nil {
+		jq.Stack.SetFilters(saved)
+		return err
+	}
+	jq.History.Record(saved, jq.Stack)
+	return nil
+}
+
+// testFilter checks that the filter on the stack is valid.  How the filter is
+// checked depends on the validate option.  By default the engine validates
+// the filter, which catches syntax errors.  With "sample" validation the
+// filter is applied to the first values of the input, which also catches
+// runtime errors like indexing an array with a string.
 func testFilter(jq *JQShell) error {
-	var empty bytes.Buffer
-	var errbuf bytes.Buffer
+	opts := jq.Options
+	var sample *bytes.Buffer
+	switch opts.Validate {
+	case ValidateNone:
+		return nil
+	case ValidateSample:
+		var err error
+		sample, err = jq.sampleInput(opts.Samples)
+		if err != nil {
+			return err
+		}
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultJQOptions().Timeout
+	}
+
 	var err error
 	stop := make(chan struct{})
 	done := make(chan struct{})
 	go func() {
-		_, _, err = Execute(ioutil.Discard, &errbuf, &empty, stop, jq.bin, false, jq.Stack)
-		close(done)
+		defer close(done)
+		if sample == nil {
+			err = jq.engine.Validate(&opts, jq.Stack, stop)
+			return
+		}
+		var errbuf bytes.Buffer
+		_, _, err = jq.engine.Execute(ioutil.Discard, &errbuf, sample, stop, false, &opts, jq.Stack)
+		if err != nil {
+			err = fmt.Errorf("%s (%v)", bytes.TrimSpace(errbuf.Bytes()), err)
+		}
 	}()
 	select {
 	case <-done:
-		if err != nil {
-			return fmt.Errorf("%s (%v)", errbuf.Bytes(), err)
-		}
-		return nil
-	case <-time.After(testFilterTimeout):
+		return err
+	case <-time.After(timeout):
 		close(stop)
 		return fmt.Errorf("jq timed out processing the filter")
+	case <-jq.interrupted():
+		close(stop)
+		return ErrInterrupted
+	}
+}
+
+// sampleInput returns the first n JSON values of the input, each on its own
+// line.  sampleInput returns nil if no input has been declared or the input is
+// streamed, because a single value may be too large to read.
+func (jq *JQShell) sampleInput(n int) (*bytes.Buffer, error) {
+	if jq.Options.Stream {
+		return nil, nil
+	}
+	r, err := jq.Input()
+	if err == ErrNoInput {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	sample := new(bytes.Buffer)
+	dec := json.NewDecoder(r)
+	for i := 0; i < n; i++ {
+		var v json.RawMessage
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading input sample: %v", err)
+		}
+		sample.Write(v)
+		sample.WriteByte('\n')
 	}
+	return sample, nil
 }
 
 func cmdPopAll(jq *JQShell, flags *CmdFlags) error {
@@ -376,8 +486,10 @@ func cmdPopAll(jq *JQShell, flags *CmdFlags) error {
 	if err != nil {
 		return err
 	}
-	jq.Stack.PopAll()
-	return nil
+	return jq.changeStack(func(s *JQStack) error {
+		s.PopAll()
+		return nil
+	})
 }
 
 func cmdPop(jq *JQShell, flags *CmdFlags) error {
@@ -409,12 +521,444 @@ func cmdPop(jq *JQShell, flags *CmdFlags) error {
 	if n < 0 {
 		return fmt.Errorf("argument must be positive")
 	}
-	_, err = jq.Stack.Pop(n)
+	err = jq.changeStack(func(s *JQStack) error {
+		_, err := s.Pop(n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdUndo(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command undo restores the filter stack to a previous state.")
+	flags.ArgSet("[n]")
+	flags.ArgDoc("n=1", "the number of changes to undo")
+	list := flags.Bool("list", false, "list previous states of the stack instead")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after undo")
+	flags.Docs(
+		"Changes made by push, pop, popall, the stack editing commands,",
+		"and commands which reset the stack when setting input can be undone.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *list {
+		fmt.Printf("[%02d] %s\n", 0, JoinFilter(jq.Stack))
+		for i, state := range jq.History.Undos() {
+			fmt.Printf("[%02d] %s\n", i+1, joinFilters(state))
+		}
+		return nil
+	}
+	n, err := parseCount(flags.Args())
+	if err != nil {
+		return err
+	}
+	if jq.History.Undo(jq.Stack, n) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdRedo(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command redo reapplies changes to the filter stack reverted by undo.")
+	flags.ArgSet("[n]")
+	flags.ArgDoc("n=1", "the number of changes to redo")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after redo")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n, err := parseCount(flags.Args())
 	if err != nil {
 		return err
 	}
+	if jq.History.Redo(jq.Stack, n) == 0 {
+		return fmt.Errorf("nothing to redo")
+	}
 	if !*quiet {
-		return cmdWrite(jq, Flags("write", nil))
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdMark(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command mark saves the filter stack under a name.")
+	flags.ArgSet("mark")
+	flags.ArgDoc("mark", "a name for the current stack")
+	del := flags.Bool("d", false, "delete the named mark instead")
+	flags.Docs("A saved stack can be restored with :goto.  An existing mark is replaced.")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expects one name")
+	}
+	name := flags.Arg(0)
+	if *del {
+		if _, ok := jq.marks[name]; !ok {
+			return fmt.Errorf("unknown mark %q", name)
+		}
+		delete(jq.marks, name)
+		return nil
+	}
+	if jq.marks == nil {
+		jq.marks = make(map[string][]Filter)
+	}
+	jq.marks[name] = jq.Stack.Filters()
+	return nil
+}
+
+func cmdGoto(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command goto restores a filter stack saved with :mark.")
+	flags.ArgSet("mark")
+	flags.ArgDoc("mark", "the name of a saved stack")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after goto")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expects one name")
+	}
+	filters, ok := jq.marks[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown mark %q", flags.Arg(0))
+	}
+	err = jq.changeStack(func(s *JQStack) error {
+		s.SetFilters(filters)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdMarks(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command marks lists the stacks saved with :mark.")
+	tree := flags.Bool("tree", false, "print the marks as a tree of filters")
+	flags.Docs("Marks matching the current stack are flagged with '*'.")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(jq.marks) == 0 {
+		fmt.Fprintln(os.Stderr, "no marks")
+		return nil
+	}
+	current := jq.Stack.Filters()
+	names := jq.markNames()
+	if *tree {
+		root := new(markNode)
+		for _, name := range names {
+			root.insert(name, jq.marks[name])
+		}
+		root.print(os.Stdout, "", current)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+	for _, name := range names {
+		cur := " "
+		if filtersEqual(jq.marks[name], current) {
+			cur = "*"
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\n", cur, name, joinFilters(jq.marks[name]))
+	}
+	return tw.Flush()
+}
+
+func (jq *JQShell) markNames() []string {
+	var names []string
+	for name := range jq.marks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markNode is a node in a tree of marked stacks.  Stacks which share filters
+// at the bottom of the stack share ancestors in the tree.
+type markNode struct {
+	filter   Filter
+	path     []Filter
+	marks    []string
+	children []*markNode
+}
+
+func (node *markNode) insert(name string, filters []Filter) {
+	if len(filters) == 0 {
+		node.marks = append(node.marks, name)
+		return
+	}
+	f := JoinFilter(filters[0])
+	for _, child := range node.children {
+		if JoinFilter(child.filter) == f {
+			child.insert(name, filters[1:])
+			return
+		}
+	}
+	path := append(append([]Filter(nil), node.path...), filters[0])
+	child := &markNode{filter: filters[0], path: path}
+	node.children = append(node.children, child)
+	child.insert(name, filters[1:])
+}
+
+func (node *markNode) print(w io.Writer, indent string, current []Filter) {
+	if node.filter != nil || len(node.marks) > 0 {
+		line := indent + "."
+		if node.filter != nil {
+			line = indent + "| " + JoinFilter(node.filter)
+			indent += "  "
+		}
+		if len(node.marks) > 0 {
+			cur := ""
+			if filtersEqual(node.path, current) {
+				cur = "*"
+			}
+			line += fmt.Sprintf("  (%s%s)", cur, strings.Join(node.marks, ", "))
+		}
+		fmt.Fprintln(w, line)
+	}
+	for _, child := range node.children {
+		child.print(w, indent, current)
+	}
+}
+
+// parseCount parses an optional positive count argument, which defaults to 1.
+func parseCount(args []string) (int, error) {
+	if len(args) > 1 {
+		return 0, fmt.Errorf("too many arguments given")
+	}
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("argument must be an integer")
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("argument must be positive")
+	}
+	return n, nil
+}
+
+// parseIndexes parses stack indexes as printed by the filter command.
+func parseIndexes(args []string) ([]int, error) {
+	idx := make([]int, len(args))
+	for i, arg := range args {
+		var err error
+		idx[i], err = strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q", arg)
+		}
+	}
+	return idx, nil
+}
+
+func cmdEdit(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command edit replaces a filter on the stack.")
+	flags.ArgSet("index", "[filter]")
+	flags.ArgDoc("index", "the index of a filter as printed by :filter")
+	flags.ArgDoc("filter", "the replacement filter (edited interactively if omitted)")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after edit")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	args := flags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("expects an index and an optional filter")
+	}
+	idx, err := parseIndexes(args[:1])
+	if err != nil {
+		return err
+	}
+	i := idx[0]
+	var filter string
+	if len(args) == 2 {
+		filter = args[1]
+	} else {
+		filters := jq.Stack.Filters()
+		if i < 0 || i >= len(filters) {
+			return fmt.Errorf("index %d out of range", i)
+		}
+		ed, ok := jq.sh.(LineEditing)
+		if !ok {
+			return fmt.Errorf("missing filter")
+		}
+		filter, err = ed.EditLine(fmt.Sprintf("[%02d] ", i), JoinFilter(filters[i]))
+		if err != nil {
+			return err
+		}
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		_, err := s.Replace(i, FilterString(filter))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdInsert(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command insert adds a filter to the stack below a given index.")
+	flags.ArgSet("index", "filter")
+	flags.ArgDoc("index", "the index the new filter will have, as printed by :filter")
+	flags.ArgDoc("filter", "a jq filter (may contain pipes '|')")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after insert")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	args := flags.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("expects an index and a filter")
+	}
+	idx, err := parseIndexes(args[:1])
+	if err != nil {
+		return err
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		return s.Insert(idx[0], FilterString(args[1]))
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdRm(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command rm removes a filter from the stack.")
+	flags.ArgSet("index")
+	flags.ArgDoc("index", "the index of a filter as printed by :filter")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after rm")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expects one index")
+	}
+	idx, err := parseIndexes(flags.Args())
+	if err != nil {
+		return err
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		_, err := s.Remove(idx[0])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdSwap(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command swap exchanges two filters on the stack.")
+	flags.ArgSet("index1", "index2")
+	flags.ArgDoc("index1, index2", "indexes of filters as printed by :filter")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after swap")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expects two indexes")
+	}
+	idx, err := parseIndexes(flags.Args())
+	if err != nil {
+		return err
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		return s.Swap(idx[0], idx[1])
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdMove(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command move moves a filter to a different position in the stack.")
+	flags.ArgSet("from", "to")
+	flags.ArgDoc("from", "the index of a filter as printed by :filter")
+	flags.ArgDoc("to", "the index the filter will have after it is moved")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after move")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expects two indexes")
+	}
+	idx, err := parseIndexes(flags.Args())
+	if err != nil {
+		return err
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		return s.Move(idx[0], idx[1])
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
 	}
 	return nil
 }
@@ -425,6 +969,14 @@ func cmdLoad(jq *JQShell, flags *CmdFlags) error {
 	flags.ArgDoc("filename", "a file contain json data")
 	quiet := flags.Bool("q", false, "quiet -- no implicit :write after setting input")
 	keepStack := flags.Bool("k", false, "keep the current filter stack after setting input")
+	stream := flags.Bool("stream", false, "apply filters to streaming events (jq --stream)")
+	remove := flags.Bool("rm", false, "delete the file when the input changes or jqsh exits")
+	as := flags.String("as", "", "name the input so it can be used again with :use and in filters as $name")
+	flags.Docs(
+		"With -stream the input is not parsed as a whole.",
+		"Filters are applied to [path, leaf] events which can be turned back",
+		"into values with :fromstream.  See :truncate for selecting events.",
+	)
 	err := flags.Parse(nil)
 	if IsHelp(err) {
 		return nil
@@ -445,13 +997,32 @@ func cmdLoad(jq *JQShell, flags *CmdFlags) error {
 	if err != nil {
 		return fmt.Errorf("error closing file")
 	}
-	jq.filename = args[0]
-	jq.istmp = false
+	if *as != "" {
+		name, err := varName(*as)
+		if err != nil {
+			return err
+		}
+		jq.addInput(name, args[0], *remove, *stream)
+		err = jq.useInput(name)
+		if err != nil {
+			return err
+		}
+	} else {
+		jq.SetInputFile(args[0], *remove)
+		jq.Options.Stream = *stream
+		if !*remove {
+			// a file which will be deleted cannot be restored by a session.
+			jq.source = &InputSource{File: args[0], Stream: *stream}
+		}
+	}
 	if !*keepStack {
-		jq.Stack.PopAll()
+		jq.changeStack(func(s *JQStack) error {
+			s.PopAll()
+			return nil
+		})
 	}
 	if !*quiet {
-		return cmdWrite(jq, Flags("write", nil))
+		return jq.writeImplicit()
 	}
 	return nil
 }
@@ -472,6 +1043,7 @@ func cmdPipe(jq *JQShell, flags *CmdFlags) error {
 	pfilename := flags.String("O", "", "like -O but the file will not be deleted by jqsh")
 	nocache := flags.Bool("c", false, "disable caching of filter input (no effect with -o)")
 	color := flags.Bool("color", false, "allow escape codes in filter output")
+	bg := flags.Bool("bg", false, "run cmd in the background with -out (see :jobs)")
 	flags.Docs(
 		"Currently it is invalid for both -in and -out to be given.",
 		"In the future it is likely that this restriction may be lifted.",
@@ -503,8 +1075,12 @@ func cmdPipe(jq *JQShell, flags *CmdFlags) error {
 		*pipein = true
 	}
 
+	if *bg && !*pipeout {
+		return fmt.Errorf("-bg requires -out")
+	}
+
 	if *pipeout {
-		return pipeTo(jq, flags.Arg(0), *color)
+		return pipeTo(jq, flags.Arg(0), *color, *bg)
 	}
 	if *pipein {
 		options := &InputPipeOptions{
@@ -518,7 +1094,7 @@ func cmdPipe(jq *JQShell, flags *CmdFlags) error {
 			options.Delete = true
 		}
 		if *pfilename != "" {
-			options.Filename = *filename
+			options.Filename = *pfilename
 		}
 		return pipeFrom(jq, flags.Arg(0), options)
 	}
@@ -547,8 +1123,16 @@ func pipeFrom(jq *JQShell, script string, options *InputPipeOptions) error {
 		path = opt.Filename
 		istmp = opt.Delete
 	}
+	source := &InputSource{
+		Script:  script,
+		Output:  opt.Filename,
+		Delete:  opt.Delete,
+		Ignore:  opt.Ignore,
+		NoCache: opt.NoCache,
+	}
 	if opt.NoCache {
 		jq.SetInput(_pipeInput(jq, "bash", "-c", script))
+		jq.source = source
 		return nil
 	}
 	if path == "" {
@@ -569,30 +1153,53 @@ func pipeFrom(jq *JQShell, script string, options *InputPipeOptions) error {
 		os.Remove(path)
 		return err
 	}
-	_, err = io.Copy(out, stdout)
+	copyerr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, stdout)
+		copyerr <- err
+	}()
+	select {
+	case err = <-copyerr:
+	case <-jq.interrupted():
+		// closing the pipe stops the copy and the command receives SIGPIPE
+		// if it has not already exited.
+		stdout.Close()
+		<-copyerr
+		if istmp {
+			os.Remove(path)
+		}
+		return ErrInterrupted
+	}
 	if err != nil {
 		os.Remove(path)
 		return err
 	}
 
 	jq.SetInputFile(path, istmp)
+	jq.source = source
 
 	if !opt.KeepStack {
-		jq.Stack.PopAll()
+		jq.changeStack(func(s *JQStack) error {
+			s.PopAll()
+			return nil
+		})
 	}
 
 	if !opt.Quiet {
-		return cmdWrite(jq, Flags("write", nil))
+		return jq.writeImplicit()
 	}
 
 	return nil
 }
 
-func pipeTo(jq *JQShell, script string, color bool) error {
+func pipeTo(jq *JQShell, script string, color, bg bool) error {
 	// warn if no input has been declared, but continue executing jq and paging
 	// output. i think this is the best thing to do.
 	// https://github.com/bmatsuo/jqsh/issues/23
 	if !jq.HasInput() {
+		if bg {
+			return ErrNoInput
+		}
 		fmt.Fprintln(os.Stderr, warnNoInput)
 	}
 
@@ -606,6 +1213,9 @@ func pipeTo(jq *JQShell, script string, color bool) error {
 	cmd := exec.Command(shell, shcmd[1:]...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
+	if bg {
+		detachProcess(cmd)
+	}
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return fmt.Errorf("creating pipe: %v", err)
@@ -620,6 +1230,23 @@ func pipeTo(jq *JQShell, script string, color bool) error {
 		return ExecError{shcmd, err}
 	}
 
+	if bg {
+		job, err := jq.startJob("pipe -out "+script, stdin, func() error {
+			err := <-waiterr
+			if err != nil {
+				return ExecError{shcmd, err}
+			}
+			return nil
+		})
+		if err != nil {
+			stdin.Close()
+			<-waiterr
+			return err
+		}
+		jq.Log.Printf("[%d] %s", job.ID, job.Command)
+		return nil
+	}
+
 	// write jq output to the process until the pipe closes or there is no more
 	// filter output to write. wait for the script process to exit before
 	// returning in any case.
@@ -640,6 +1267,7 @@ func cmdWrite(jq *JQShell, flags *CmdFlags) error {
 	flags.About("Command write writes filter output to a file or stdout.")
 	flags.ArgSet("[filename]")
 	flags.ArgDoc("filename", "write to a file instead of stdout/pager")
+	bg := flags.Bool("bg", false, "write the file in the background (see :jobs)")
 	err := flags.Parse(nil)
 	if IsHelp(err) {
 		return nil
@@ -647,6 +1275,12 @@ func cmdWrite(jq *JQShell, flags *CmdFlags) error {
 	if err != nil {
 		return err
 	}
+	if *bg {
+		if flags.NArg() != 1 {
+			return fmt.Errorf("-bg requires a filename")
+		}
+		return cmdWrite_bg(jq, flags.Arg(0))
+	}
 
 	// warn if no input has been declared, but continue executing jq and paging
 	// output. i think this is the best thing to do.
@@ -657,13 +1291,25 @@ func cmdWrite(jq *JQShell, flags *CmdFlags) error {
 
 	args := flags.Args()
 	if len(args) == 0 {
+		if !jq.paging() {
+			_, _, err := cmdWrite_io(jq, nopWriteCloser{os.Stdout}, false, nil)
+			return err
+		}
 		return cmdWrite_page(jq)
 	}
 	return cmdWrite_file(jq, args[0])
 }
 
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (w nopWriteCloser) Close() error {
+	return nil
+}
+
 func cmdWrite_page(jq *JQShell) error {
-	w, errch := Page(nil)
+	w, errch := Page(jq.Options.pager())
 	select {
 	case err := <-errch:
 		return err
@@ -680,7 +1326,7 @@ func cmdWrite_page(jq *JQShell) error {
 		}
 		close(pageerr)
 	}()
-	_, _, err := cmdWrite_io(jq, w, true, stop)
+	_, _, err := cmdWrite_io(jq, w, jq.Options.Color, stop)
 	if err != nil {
 		return err
 	}
@@ -703,23 +1349,73 @@ func cmdWrite_file(jq *JQShell, filename string) error {
 	return err
 }
 
-func cmdWrite_io(jq *JQShell, w io.WriteCloser, color bool, stop chan struct{}) (int64, int64, error) {
+func cmdWrite_bg(jq *JQShell, filename string) error {
+	if !jq.HasInput() {
+		return ErrNoInput
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	job, err := jq.startJob("write "+filename, f, nil)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	jq.Log.Printf("[%d] writing %q", job.ID, filename)
+	return nil
+}
+
+func cmdWrite_io(jq *JQShell, w io.WriteCloser, color bool, stop <-chan struct{}) (int64, int64, error) {
 	defer w.Close()
-	r, err := jq.Input()
-	if err == ErrNoInput {
+	if !jq.HasInput() {
 		return 0, 0, nil
 	}
-	if err != nil {
-		return 0, 0, err
+	done := make(chan struct{})
+	defer close(done)
+	intr := jq.interrupted()
+	stop = mergeStop(done, stop, intr)
+	var nout, nerr int64
+	var err error
+	if jq.caching() {
+		nout, nerr, err = jq.executeCached(w, os.Stderr, stop, color)
+	} else {
+		nout, nerr, err = jq.executeInput(w, os.Stderr, stop, color, &jq.Options, jq.Stack)
+	}
+	select {
+	case <-intr:
+		return nout, nerr, ErrInterrupted
+	default:
 	}
-	defer r.Close()
-	nout, nerr, err := Execute(w, os.Stderr, r, stop, jq.bin, color, jq.Stack)
 	if err != nil {
 		return nout, nerr, ExecError{[]string{"jq"}, err}
 	}
 	return nout, nerr, err
 }
 
+// mergeStop returns a channel that is closed when either a or b is closed.
+// Either may be nil.  The goroutine waiting on a and b exits when done is
+// closed.
+func mergeStop(done, a, b <-chan struct{}) <-chan struct{} {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-a:
+		case <-b:
+		case <-done:
+			return
+		}
+		close(stop)
+	}()
+	return stop
+}
+
 func cmdRaw(jq *JQShell, flags *CmdFlags) error {
 	flags.About("Command raw writes input to a file without applying the filter.")
 	flags.ArgSet("[filename]")
@@ -738,7 +1434,11 @@ func cmdRaw(jq *JQShell, flags *CmdFlags) error {
 			return err
 		}
 		defer r.Close()
-		w, errch := Page(nil)
+		if !jq.paging() {
+			_, err = io.Copy(os.Stdout, r)
+			return err
+		}
+		w, errch := Page(jq.Options.pager())
 		select {
 		case err := <-errch:
 			return err
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// runScript runs script in a shell reading input and returns what the shell
+// wrote to stdout.
+func runScript(t *testing.T, input, script string) (string, error) {
+	f, err := ioutil.TempFile("", "jqsh-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, input)
+	f.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	script = ":load " + f.Name() + "\n" + script
+	jq := NewScriptJQShell(new(GoEngine), StringShellReader(script), false)
+	err = jq.Wait()
+	w.Close()
+	os.Stdout = stdout
+	out, _ := ioutil.ReadAll(r)
+	return string(out), err
+}
+
+func TestMarkCommands(t *testing.T) {
+	input := `{"a": {"b": 1, "c": 2}}`
+	for i, test := range []struct {
+		script string
+		out    string
+		err    string
+	}{
+		{
+			":push .a\n:push .b\n:mark b\n:undo\n:undo\n:goto b\n:write\n",
+			"1\n", "",
+		},
+		{
+			":push .a\n:mark a\n:push .c\n:goto a\n:undo\n:write\n",
+			"2\n", "",
+		},
+		{
+			":push .a\n:mark a\n:goto b\n",
+			"", `unknown mark "b"`,
+		},
+		{
+			":mark -d a\n",
+			"", `unknown mark "a"`,
+		},
+		{
+			":push .a\n:push .b\n:mark b\n:pop\n:push .c\n:mark c\n:push tostring\n:mark s\n:marks -tree\n",
+			"| .a\n  | .b  (b)\n  | .c  (c)\n    | tostring  (*s)\n", "",
+		},
+		{
+			":push .a\n:mark a\n:push .b\n:mark b\n:marks\n",
+			"  a  .a\n* b  .a | .b\n", "",
+		},
+	} {
+		out, err := runScript(t, input, test.script)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test %d: error %v (expect %q)", i, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("test %d: got %q (expect %q)", i, out, test.out)
+		}
+	}
+}
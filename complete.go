@@ -0,0 +1,434 @@
+// complete.go
+// completion of partially typed shell input
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Complete returns candidates completing the word before pos in line.  Its
+// signature matches Completer.
+func (jq *JQShell) Complete(line string, pos int) (int, []string) {
+	text := line[:pos]
+	switch {
+	case strings.HasPrefix(text, "?"):
+		start, cands := jq.completePath(text[1:])
+		return start + 1, cands
+	case !strings.HasPrefix(text, ":"):
+		return jq.completePath(text)
+	}
+	toks, err := splitCommand(text[1:], 1)
+	if err != nil {
+		return pos, nil
+	}
+	if len(toks) == 0 || toks[len(toks)-1].end < len(text)-1 {
+		toks = append(toks, cmdToken{"", len(text) - 1, len(text) - 1})
+	}
+	words := make([]string, len(toks))
+	for i, tok := range toks {
+		words[i] = tok.text
+	}
+	last := toks[len(toks)-1]
+	cands := jq.lib.Complete(jq, words)
+	if last.start == 0 || text[last.start] != '+' {
+		// the word will be tokenized again when the line is entered.
+		for i, cand := range cands {
+			cands[i] = escapeWord(cand)
+		}
+	}
+	return last.start + 1, cands
+}
+
+// escapeWord escapes the special characters in a completion candidate so it
+// is tokenized as one word.  A trailing space separating the candidate from
+// the next word is not escaped.
+func escapeWord(s string) string {
+	trimmed := strings.TrimSuffix(s, " ")
+	var buf bytes.Buffer
+	for _, c := range trimmed {
+		if unicode.IsSpace(c) || strings.ContainsRune(`\'"`, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	buf.WriteString(s[len(trimmed):])
+	return buf.String()
+}
+
+// Complete returns candidates for the last word in a command line, words.  The
+// first word is the command name.  Candidates are computed from the registered
+// commands, their flags and arguments, and the registered help topics.
+func (lib *Lib) Complete(jq *JQShell, words []string) []string {
+	lib.mut.Lock()
+	defer lib.mut.Unlock()
+	word := words[len(words)-1]
+	if len(words) == 1 {
+		return completePrefix(lib.commandNames(), word, " ")
+	}
+	flags := lib.cmdFlags(jq, words[0])
+	if flags == nil {
+		return nil
+	}
+	if strings.HasPrefix(word, "-") {
+		var names []string
+		flags.VisitAll(func(f *flag.Flag) {
+			names = append(names, "-"+f.Name)
+		})
+		return completePrefix(names, word, " ")
+	}
+	arg, ok := flags.argName(words[1 : len(words)-1])
+	if !ok {
+		return nil
+	}
+	switch {
+	case strings.Contains(arg, "topic"):
+		names := lib.commandNames()
+		for name := range lib.topics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return completePrefix(names, word, " ")
+	case strings.Contains(arg, "file"):
+		return completeFile(word)
+	case strings.Contains(arg, "option"):
+		return completePrefix(optionNames(), word, " ")
+	case strings.Contains(arg, "alias"):
+		var names []string
+		for _, a := range lib.aliases() {
+			names = append(names, a.Name)
+		}
+		return completePrefix(names, word, " ")
+	case strings.Contains(arg, "function") && jq != nil:
+		return completePrefix(jq.defNames(), word, " ")
+	case strings.Contains(arg, "variable") && jq != nil:
+		return completePrefix(jq.varNames(), word, " ")
+	case strings.Contains(arg, "input") && jq != nil:
+		return completePrefix(jq.inputNames(), word, " ")
+	case strings.Contains(arg, "mark") && jq != nil:
+		return completePrefix(jq.markNames(), word, " ")
+	case strings.Contains(arg, "filter") && jq != nil:
+		start, cands := jq.completePath(word)
+		for i := range cands {
+			cands[i] = word[:start] + cands[i]
+		}
+		return cands
+	}
+	return nil
+}
+
+func (lib *Lib) commandNames() []string {
+	var names []string
+	for name := range lib.cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cmdFlags returns the flags and arguments declared by the named command.
+// The command is executed with the "-h" flag and its output is discarded,
+// the same way the help listing produces synopses.
+func (lib *Lib) cmdFlags(jq *JQShell, name string) *CmdFlags {
+	cmd, ok := lib.cmds[name]
+	if !ok {
+		return nil
+	}
+	flags := Flags(name, []string{"-h"})
+	flags.SetOutput(ioutil.Discard)
+	cmd.ExecuteShellCommand(jq, flags)
+	return flags
+}
+
+// argName returns the name of the positional argument following args, as
+// declared by the first ArgSet.  Flags in args, and the values of non-boolean
+// flags, are skipped.
+func (f *CmdFlags) argName(args []string) (string, bool) {
+	if len(f.argsets) == 0 {
+		return "", false
+	}
+	var n int
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || n > 0 {
+			n++
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		fl := f.Lookup(name)
+		if fl == nil {
+			continue
+		}
+		if b, ok := fl.Value.(interface {
+			IsBoolFlag() bool
+		}); ok && b.IsBoolFlag() {
+			continue
+		}
+		i++ // skip the flag's value
+	}
+	set := f.argsets[0]
+	var arg string
+	switch {
+	case n < len(set):
+		arg = set[n]
+	case len(set) > 1 && set[len(set)-1] == "...":
+		arg = set[len(set)-2]
+	default:
+		return "", false
+	}
+	if arg == "..." {
+		arg = set[n-1]
+	}
+	return strings.Trim(arg, "[]"), true
+}
+
+// completePrefix returns the strings in strs having prefix, each with suffix
+// appended.
+func completePrefix(strs []string, prefix, suffix string) []string {
+	var cands []string
+	for _, s := range strs {
+		if strings.HasPrefix(s, prefix) {
+			cands = append(cands, s+suffix)
+		}
+	}
+	return cands
+}
+
+// completeFile returns the paths of files having prefix.  Directories are
+// completed with a trailing slash so that their contents may be completed
+// next.
+func completeFile(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	readdir := dir
+	if readdir == "" {
+		readdir = "."
+	}
+	f, err := os.Open(readdir)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil
+	}
+	var cands []string
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if info.IsDir() {
+			cands = append(cands, dir+name+string(filepath.Separator))
+		} else {
+			cands = append(cands, dir+name+" ")
+		}
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+// the maximum number of filter output values examined when completing a path
+// and the maximum time allowed to examine them.
+var (
+	pathCompletionLimit   = 1000
+	pathCompletionTimeout = 3 * time.Second
+)
+
+// pathCompletion describes the values found at a path in the filter output.
+type pathCompletion struct {
+	keys  []string
+	array bool
+}
+
+// completePath returns candidates completing a path expression (e.g.
+// ".items[].na") at the end of filter.  Candidates are computed by applying
+// the filter stack and the incomplete filter to the input and collecting the
+// object keys found there.  Candidates replace filter[start:].
+func (jq *JQShell) completePath(filter string) (start int, cands []string) {
+	i := strings.LastIndexFunc(filter, func(c rune) bool {
+		return !(isWordRune(c) || c == '.' || c == '[' || c == ']')
+	})
+	path := filter[i+1:]
+	dot := strings.LastIndex(path, ".")
+	if dot < 0 {
+		return len(filter), nil
+	}
+	partial := path[dot+1:]
+	if strings.IndexFunc(partial, func(c rune) bool { return !isWordRune(c) }) >= 0 {
+		return len(filter), nil
+	}
+	start = i + 1 + dot
+	base := path[:dot]
+
+	expr := pathContext(filter[:i+1])
+	if base != "" {
+		expr = append(expr, base)
+	}
+	pc := jq.lookupPath(expr)
+	if pc == nil {
+		return start, nil
+	}
+	if pc.array && partial == "" {
+		if base == "" {
+			cands = append(cands, ".[]")
+		} else {
+			cands = append(cands, "[]")
+		}
+	}
+	for _, key := range pc.keys {
+		if isIdentifier(key) {
+			if strings.HasPrefix(key, partial) {
+				cands = append(cands, "."+key)
+			}
+		} else if partial == "" {
+			bs, _ := json.Marshal(key)
+			cands = append(cands, "."+string(bs))
+		}
+	}
+	return start, cands
+}
+
+// lookupPath returns the keys of objects (and whether there are arrays)
+// output when the filters in expr are applied to the output of the filter
+// stack.  Results are cached until the input, the stack or the options
+// affecting the filter's input change.
+func (jq *JQShell) lookupPath(expr []string) *pathCompletion {
+	if !jq.HasInput() || jq.Options.Stream {
+		return nil
+	}
+	filter := JoinFilter(jq.Stack)
+	if len(expr) > 0 {
+		filter += FilterJoinString + strings.Join(expr, FilterJoinString)
+	}
+	key := fmt.Sprintf("%d\x00%v\x00%s", jq.inputgen, jq.Options.filterOptions().Args(), filter)
+	if pc, ok := jq.paths[key]; ok {
+		return pc
+	}
+	if jq.paths == nil || len(jq.paths) > 256 {
+		jq.paths = make(map[string]*pathCompletion)
+	}
+
+	query := fmt.Sprintf(`[limit(%d; (%s)?) | if type == "object" then keys[] elif type == "array" then 0 else empty end] | unique`, pathCompletionLimit, filter)
+	s := new(JQStack)
+	s.Push(FilterString(query))
+	r, err := jq.Input()
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := jq.engine.Execute(&out, ioutil.Discard, r, stop, false, jq.Options.filterOptions(), s)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(pathCompletionTimeout):
+		close(stop)
+		<-done
+		return nil
+	}
+	if err != nil {
+		// the filter is probably incomplete.  don't cache the failure because
+		// the input may be a pipe.
+		return nil
+	}
+	var vals []interface{}
+	pc := new(pathCompletion)
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		err = dec.Decode(&vals)
+		if err != nil {
+			return nil
+		}
+		for _, v := range vals {
+			switch v := v.(type) {
+			case string:
+				pc.keys = append(pc.keys, v)
+			default:
+				pc.array = true
+			}
+		}
+	}
+	jq.paths[key] = pc
+	return pc
+}
+
+// pathContext returns the filters whose output is the input of the expression
+// at the end of prefix.  The expression receives the input of the enclosing
+// pipeline, so the context consists of the pipeline stages preceding it at
+// each level of nesting.  Arguments to map() and map_values() are applied to
+// the values being mapped.
+func pathContext(prefix string) []string {
+	type frame struct {
+		start int
+		pipe  int
+	}
+	frames := []frame{{0, -1}}
+	for i := 0; i < len(prefix); i++ {
+		top := &frames[len(frames)-1]
+		switch prefix[i] {
+		case '"':
+			for i++; i < len(prefix) && prefix[i] != '"'; i++ {
+				if prefix[i] == '\\' {
+					i++
+				}
+			}
+		case '(', '[', '{':
+			frames = append(frames, frame{i + 1, -1})
+		case ')', ']', '}':
+			if len(frames) > 1 {
+				frames = frames[:len(frames)-1]
+			}
+		case '|':
+			top.pipe = i
+		}
+	}
+	var ctx []string
+	for i, f := range frames {
+		if f.pipe >= 0 {
+			seg := strings.TrimSpace(prefix[f.start:f.pipe])
+			if seg != "" {
+				ctx = append(ctx, seg)
+			}
+		}
+		if i+1 < len(frames) {
+			opener := strings.TrimSpace(prefix[:frames[i+1].start-1])
+			if strings.HasSuffix(opener, "map") || strings.HasSuffix(opener, "map_values") {
+				ctx = append(ctx, ".[]")
+			}
+		}
+	}
+	return ctx
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
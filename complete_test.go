@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testLibrary() *Lib {
+	lib := Library(nil)
+	lib.Register("push", JQShellCommandFunc(cmdPush))
+	lib.Register("pop", JQShellCommandFunc(cmdPop))
+	lib.Register("pipe", JQShellCommandFunc(cmdPipe))
+	lib.Register("load", JQShellCommandFunc(cmdLoad))
+	lib.RegisterHelp("syntax", "Topic syntax is a test topic.")
+	return lib
+}
+
+func TestLibComplete(t *testing.T) {
+	lib := testLibrary()
+	for i, test := range []struct {
+		words []string
+		cands []string
+	}{
+		{[]string{""}, []string{"help ", "load ", "pipe ", "pop ", "push "}},
+		{[]string{"p"}, []string{"pipe ", "pop ", "push "}},
+		{[]string{"pu"}, []string{"push "}},
+		{[]string{"x"}, nil},
+		{[]string{"push", "-"}, []string{"-q "}},
+		{[]string{"pipe", "-i"}, []string{"-ignore ", "-in "}},
+		{[]string{"load", "-k"}, []string{"-k "}},
+		{[]string{"help", "s"}, []string{"syntax "}},
+		{[]string{"help", "po"}, []string{"pop "}},
+		{[]string{"help", "pop", ""}, nil},
+		{[]string{"pop", ""}, nil},
+		{[]string{"unknown", ""}, nil},
+	} {
+		cands := lib.Complete(nil, test.words)
+		if !reflect.DeepEqual(cands, test.cands) {
+			t.Errorf("test %d %q: got %q (expect %q)", i, test.words, cands, test.cands)
+		}
+	}
+}
+
+func TestLibCompleteInput(t *testing.T) {
+	lib := Library(nil)
+	lib.Register("use", JQShellCommandFunc(cmdUse))
+	jq := &JQShell{}
+	jq.registerInput("orders", "orders.json", false, false)
+	jq.registerInput("users", "users.json", false, false)
+	for i, test := range []struct {
+		words []string
+		cands []string
+	}{
+		{[]string{"use", ""}, []string{"orders ", "users "}},
+		{[]string{"use", "u"}, []string{"users "}},
+		{[]string{"use", "x"}, nil},
+	} {
+		cands := lib.Complete(jq, test.words)
+		if !reflect.DeepEqual(cands, test.cands) {
+			t.Errorf("test %d %q: got %q (expect %q)", i, test.words, cands, test.cands)
+		}
+	}
+}
+
+func TestCmdFlagsArgName(t *testing.T) {
+	flags, _ := testFlags("test", nil)
+	flags.ArgSet("filename", "[topic]")
+	flags.Bool("b", false, "a bool")
+	flags.String("s", "", "a string")
+	for i, test := range []struct {
+		args []string
+		name string
+		ok   bool
+	}{
+		{nil, "filename", true},
+		{[]string{"-b"}, "filename", true},
+		{[]string{"-s", "x"}, "filename", true},
+		{[]string{"-s=x"}, "filename", true},
+		{[]string{"a"}, "topic", true},
+		{[]string{"-b", "a"}, "topic", true},
+		{[]string{"a", "b"}, "", false},
+	} {
+		name, ok := flags.argName(test.args)
+		if name != test.name || ok != test.ok {
+			t.Errorf("test %d %q: got (%q, %v) (expect (%q, %v))", i, test.args, name, ok, test.name, test.ok)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for i, test := range []struct {
+		strs   []string
+		prefix string
+	}{
+		{nil, ""},
+		{[]string{"abc"}, "abc"},
+		{[]string{"abc", "abd"}, "ab"},
+		{[]string{"abc", "xyz"}, ""},
+		{[]string{"héllo", "hélp"}, "hél"},
+	} {
+		prefix := commonPrefix(test.strs)
+		if prefix != test.prefix {
+			t.Errorf("test %d %q: got %q (expect %q)", i, test.strs, prefix, test.prefix)
+		}
+	}
+}
+
+func TestPathContext(t *testing.T) {
+	for i, test := range []struct {
+		prefix string
+		ctx    []string
+	}{
+		{"", nil},
+		{".a | ", []string{".a"}},
+		{".a | .b | ", []string{".a | .b"}},
+		{"select(", nil},
+		{".a | select(.b | ", []string{".a", ".b"}},
+		{".a | select(.b) | ", []string{".a | select(.b)"}},
+		{"map(", []string{".[]"}},
+		{".a | map(", []string{".a", ".[]"}},
+		{`select(.a == "|") | `, []string{`select(.a == "|")`}},
+		{"{a: .x, b: ", nil},
+	} {
+		ctx := pathContext(test.prefix)
+		if !reflect.DeepEqual(ctx, test.ctx) {
+			t.Errorf("test %d %q: got %q (expect %q)", i, test.prefix, ctx, test.ctx)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// defs.go
+// jq function definitions included in every filter
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// JQDef is jq program text defining functions, or importing modules, which is
+// placed before every filter.
+type JQDef struct {
+	Text  string   `json:"text"`
+	Names []string `json:"names,omitempty"` // the names of the functions defined, with arity (e.g. "f/1")
+	File  string   `json:"file,omitempty"`  // the file Text was read from
+}
+
+// NewJQDef returns a definition for text, which must consist of jq function
+// definitions and module directives each terminated by a semicolon.
+func NewJQDef(text string) (JQDef, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return JQDef{}, fmt.Errorf("empty definition")
+	}
+	if !strings.HasSuffix(strings.TrimSpace(stripComment(text)), ";") {
+		return JQDef{}, fmt.Errorf("definition must end with a semicolon")
+	}
+	return JQDef{Text: text, Names: defNames(text)}, nil
+}
+
+// isDirective returns true if d imports or includes a module.  Directives must
+// precede function definitions in a jq program.
+func (d JQDef) isDirective() bool {
+	return strings.HasPrefix(d.Text, "import") || strings.HasPrefix(d.Text, "include")
+}
+
+// defNames returns the names and arities of the functions defined at the top
+// level of text.  Functions defined in the body of another are not included.
+func defNames(text string) []string {
+	names, _ := scanDefs(text)
+	return names
+}
+
+// defsEnd returns the offset in text following the semicolon which terminates
+// the last top-level function definition, or -1 if a definition in text is not
+// terminated.  Any filter using the definitions begins at the offset.
+func defsEnd(text string) int {
+	_, end := scanDefs(text)
+	return end
+}
+
+// scanDefs returns the results of defNames and defsEnd for text.
+func scanDefs(text string) (names []string, end int) {
+	var depth int // brackets, parentheses and braces
+	var defs int  // definitions which are not terminated
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			for i++; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+		case c == '#':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+		case c == ';' && depth == 0:
+			if defs > 0 {
+				defs--
+				if defs == 0 {
+					end = i + 1
+				}
+			}
+		case depth == 0 && isKeyword(text, i, "def"):
+			if defs == 0 {
+				names = append(names, defSignature(text[i+len("def"):]))
+			}
+			defs++
+			i += len("def") - 1
+		case isWordRune(rune(c)) || c == '$':
+			// skip the rest of the word so it is not mistaken for a keyword.
+			for i+1 < len(text) && isWordRune(rune(text[i+1])) {
+				i++
+			}
+		}
+	}
+	if defs > 0 || depth > 0 {
+		end = -1
+	}
+	return names, end
+}
+
+// isKeyword returns true if the word at text[i:] is kw.
+func isKeyword(text string, i int, kw string) bool {
+	if !strings.HasPrefix(text[i:], kw) {
+		return false
+	}
+	if i > 0 && (text[i-1] == '.' || text[i-1] == '$') {
+		return false
+	}
+	j := i + len(kw)
+	return j == len(text) || !isWordRune(rune(text[j]))
+}
+
+// defSignature returns the name and arity of the function whose definition
+// follows the "def" keyword in text.
+func defSignature(text string) string {
+	text = strings.TrimLeft(text, " \t\r\n")
+	end := strings.IndexFunc(text, func(c rune) bool { return !isWordRune(c) })
+	if end < 0 {
+		end = len(text)
+	}
+	name := text[:end]
+	params := strings.TrimLeft(text[end:], " \t\r\n")
+	arity := 0
+	if strings.HasPrefix(params, "(") {
+		if i := strings.Index(params, ")"); i >= 0 {
+			arity = strings.Count(params[:i], ";") + 1
+		}
+	}
+	return fmt.Sprintf("%s/%d", name, arity)
+}
+
+// Program returns filter preceded by the definitions in o.  Module
+// directives are placed first.
+func (o *JQOptions) Program(filter string) string {
+	if o == nil || len(o.Defs) == 0 {
+		return filter
+	}
+	var prog []string
+	for _, d := range o.Defs {
+		if d.isDirective() {
+			prog = append(prog, d.Text)
+		}
+	}
+	for _, d := range o.Defs {
+		if !d.isDirective() {
+			prog = append(prog, d.Text)
+		}
+	}
+	return strings.Join(append(prog, filter), "\n")
+}
+
+// AddDef adds d to the definitions in o.  Existing definitions of the
+// functions d defines are removed.
+func (o *JQOptions) AddDef(d JQDef) {
+	defs := make([]JQDef, 0, len(o.Defs)+1)
+	for _, old := range o.Defs {
+		if !defines(old, d.Names...) {
+			defs = append(defs, old)
+		}
+	}
+	o.Defs = append(defs, d)
+}
+
+// RemoveDefs removes the definitions of the named functions.  A name without
+// an arity (e.g. "f" instead of "f/1") matches functions of any arity.
+// RemoveDefs returns the number of definitions removed.
+func (o *JQOptions) RemoveDefs(names ...string) int {
+	defs := make([]JQDef, 0, len(o.Defs))
+	for _, d := range o.Defs {
+		if !defines(d, names...) {
+			defs = append(defs, d)
+		}
+	}
+	n := len(o.Defs) - len(defs)
+	o.Defs = defs
+	return n
+}
+
+// defines returns true if d defines a function matching any of names.
+func defines(d JQDef, names ...string) bool {
+	for _, sig := range d.Names {
+		for _, name := range names {
+			if sig == name || strings.HasPrefix(sig, name+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (jq *JQShell) defNames() []string {
+	var names []string
+	for _, d := range jq.Options.Defs {
+		names = append(names, d.Names...)
+	}
+	return names
+}
+
+func cmdDef(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command def defines jq functions for use in every filter.")
+	flags.ArgSet("definition")
+	flags.ArgDoc("definition", "jq function definitions (e.g. \"f: .a;\" or \"def f: .a;\"), or a file with -f")
+	flags.Docs(
+		"The \"def\" keyword may be left out of the first definition, as in",
+		"\":def inc(f): f + 1;\".",
+		"",
+		"Definitions replace earlier definitions of the same functions.  A line",
+		"beginning with \"def \" is shorthand for \":def +<line>\", unless a filter",
+		"follows the definitions, in which case the line is pushed as a filter.",
+		"Definitions may also import or include jq modules found in the libpath",
+		"option.",
+		"",
+		"\t> def inc(f): f + 1;",
+		"\t> :push inc(.count)",
+	)
+	file := flags.Bool("f", false, "read definitions from a file")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after def")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects a definition")
+	}
+	text := strings.TrimSpace(strings.Join(flags.Args(), " "))
+	if *file {
+		if flags.NArg() != 1 {
+			return fmt.Errorf("expects one file")
+		}
+		bs, err := ioutil.ReadFile(text)
+		if err != nil {
+			return err
+		}
+		text = string(bs)
+	} else if !isKeyword(text, 0, "def") && !isKeyword(text, 0, "import") && !isKeyword(text, 0, "include") {
+		text = "def " + text
+	}
+	d, err := NewJQDef(text)
+	if err != nil {
+		return err
+	}
+	if *file {
+		d.File, err = filepath.Abs(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+	}
+
+	// jq rejects definitions with syntax errors and trailing expressions.
+	saved := jq.Options.Defs
+	jq.Options.AddDef(d)
+	err = testFilter(jq)
+	if err != nil {
+		jq.Options.Defs = saved
+		return fmt.Errorf("invalid definition: %v", err)
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdUndef(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command undef removes function definitions.")
+	flags.ArgSet("function", "...")
+	flags.ArgDoc("function", "a function name, optionally with an arity (e.g. \"f/1\")")
+	flags.Docs(
+		"Definitions made together (e.g. from a file) are removed together.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects at least one function")
+	}
+	saved := jq.Options.Defs
+	for _, name := range flags.Args() {
+		if jq.Options.RemoveDefs(name) == 0 {
+			jq.Options.Defs = saved
+			return fmt.Errorf("unknown function %q", name)
+		}
+	}
+	err = testFilter(jq)
+	if err != nil {
+		jq.Options.Defs = saved
+		return fmt.Errorf("the filter stack uses the definitions: %v", err)
+	}
+	return nil
+}
+
+func cmdDefs(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command defs lists the functions defined with :def.")
+	text := flags.Bool("text", false, "print the text of definitions")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *text {
+		for _, d := range jq.Options.Defs {
+			fmt.Println(d.Text)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+	for _, d := range jq.Options.Defs {
+		names := strings.Join(d.Names, " ")
+		if d.isDirective() {
+			names = strings.SplitN(d.Text, "\n", 2)[0]
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", names, d.File)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefNames(t *testing.T) {
+	for i, test := range []struct {
+		text  string
+		names []string
+	}{
+		{"def f: .;", []string{"f/0"}},
+		{"def f(g): g; def h($a; b): $a;", []string{"f/1", "h/2"}},
+		{"def f: def g: 3; g; def h: .def;", []string{"f/0", "h/0"}},
+		{`def f: "def x: 1;" | reduce .[] as $x (0; . + $x);`, []string{"f/0"}},
+		{"import \"a\" as a; def undef: 1; # def no: 1;", []string{"undef/0"}},
+	} {
+		names := defNames(test.text)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("test %d (%q): got %q (expect %q)", i, test.text, names, test.names)
+		}
+	}
+}
+
+func TestJQOptionsDefs(t *testing.T) {
+	var opts JQOptions
+	if prog := opts.Program("."); prog != "." {
+		t.Errorf("program without defs %q", prog)
+	}
+	for _, text := range []string{"def f: 1;", "def g: 2; def h: 3;", "include \"lib\";", "def f: 4;"} {
+		d, err := NewJQDef(text)
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		opts.AddDef(d)
+	}
+	expect := "include \"lib\";\ndef g: 2; def h: 3;\ndef f: 4;\n."
+	if prog := opts.Program("."); prog != expect {
+		t.Errorf("program %q (expect %q)", prog, expect)
+	}
+	if n := opts.RemoveDefs("h/0"); n != 1 {
+		t.Errorf("removed %d definitions for h/0", n)
+	}
+	if n := opts.RemoveDefs("f/1", "g"); n != 0 {
+		t.Errorf("removed %d definitions for f/1", n)
+	}
+	if n := opts.RemoveDefs("f"); n != 1 {
+		t.Errorf("removed %d definitions for f", n)
+	}
+	_, err := NewJQDef("def f: 1")
+	if err == nil {
+		t.Errorf("accepted a definition without a semicolon")
+	}
+}
+
+func TestDefsEnd(t *testing.T) {
+	for i, test := range []struct {
+		text string
+		end  int
+	}{
+		{"def f: .;", 9},
+		{"def f: .; f", 9},
+		{"def f: def g: 3; g; f", 19},
+		{"def f: reduce .[] as $x (0; . + $x); f", 36},
+		{"def f: .", -1},
+		{"def f: (.;", -1},
+		{"def f: .; def g:", -1},
+	} {
+		end := defsEnd(test.text)
+		if end != test.end {
+			t.Errorf("test %d (%q): got %d (expect %d)", i, test.text, end, test.end)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// engine.go
+// the implementations of jq that filters are applied with
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// An Engine applies jq filters to JSON input.
+type Engine interface {
+	// Execute applies the filter in s, preceded by any definitions in opts,
+	// to the values read from in.  Output is written to outw and error
+	// messages to errw.  Execute returns the number of bytes written to each
+	// and stops early if stop is closed.  opts may be nil.
+	Execute(outw, errw io.Writer, in io.Reader, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error)
+
+	// Validate returns an error describing why the filter in s, preceded by
+	// any definitions in opts, is not a valid jq program.  Validate gives up
+	// if stop is closed.
+	Validate(opts *JQOptions, s *JQStack, stop <-chan struct{}) error
+}
+
+// A Preparer is an Engine which can parse input once and then apply any
+// number of filters to it.
+type Preparer interface {
+	Engine
+
+	// Prepare parses the values read from in.  Prepare gives up if stop is
+	// closed.
+	Prepare(in io.Reader, stop <-chan struct{}) (PreparedInput, error)
+}
+
+// PreparedInput is input parsed by a Preparer.  Its methods are not safe to
+// call concurrently.
+type PreparedInput interface {
+	// Execute is like Engine.Execute but applies the filter to the prepared
+	// input.
+	Execute(outw, errw io.Writer, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error)
+}
+
+// engines holds the names of the engines available with the -engine flag and
+// a description of each.
+var engines = map[string]string{
+	"jq":     "run the jq executable for each command",
+	"go":     "apply filters inside jqsh with gojq (jq does not need to be installed)",
+	"worker": "like go, but input is parsed once and kept in memory until it changes",
+}
+
+// engineNames returns the names of the available engines.
+func engineNames() []string {
+	var names []string
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEngine returns the named engine.  The "jq" engine runs the jq
+// executable at path bin.
+func NewEngine(name, bin string) (Engine, error) {
+	switch name {
+	case "jq":
+		return &ProcessEngine{Bin: bin}, nil
+	case "go":
+		return new(GoEngine), nil
+	case "worker":
+		return new(WorkerEngine), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q (expect one of %s)", name, strings.Join(engineNames(), ", "))
+	}
+}
+
+// ProcessEngine applies filters by running the jq executable.
+type ProcessEngine struct {
+	Bin    string // the path of jq, found on PATH if empty
+	Detach bool   // run jq in its own process group
+}
+
+var _ Engine = (*ProcessEngine)(nil)
+
+func (e *ProcessEngine) Execute(outw, errw io.Writer, in io.Reader, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	cmd := jqCommand(e.Bin, color, opts, s)
+	if e.Detach {
+		detachProcess(cmd)
+	}
+	return runCommand(cmd, outw, errw, in, stop)
+}
+
+// Validate runs jq with no input.  Options that change how input is read
+// are ignored.
+func (e *ProcessEngine) Validate(opts *JQOptions, s *JQStack, stop <-chan struct{}) error {
+	var _opts JQOptions
+	if opts != nil {
+		_opts = *opts
+	}
+	_opts.Slurp = false
+	_opts.NullInput = false
+	var errbuf bytes.Buffer
+	_, _, err := e.Execute(ioutil.Discard, &errbuf, new(bytes.Buffer), stop, false, &_opts, s)
+	if err != nil {
+		return fmt.Errorf("%s (%v)", bytes.TrimSpace(errbuf.Bytes()), err)
+	}
+	return nil
+}
+
+// background returns an engine like e which is suitable for running jobs in
+// the background.  Processes are detached from the terminal so Ctrl-C at the
+// prompt does not kill them.
+func background(e Engine) Engine {
+	if pe, ok := e.(*ProcessEngine); ok {
+		detached := *pe
+		detached.Detach = true
+		return &detached
+	}
+	return e
+}
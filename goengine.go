@@ -0,0 +1,412 @@
+// goengine.go
+// an engine applying filters inside jqsh with gojq
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/itchyny/gojq"
+)
+
+var errGoCompile = fmt.Errorf("compile error")
+var errGoFilter = fmt.Errorf("the filter failed")
+
+// GoEngine applies filters inside jqsh using gojq, an implementation of jq
+// written in Go.  It does not need jq to be installed and does not start a
+// process for each command.  Output is never colored and object keys are
+// always sorted.
+type GoEngine struct{}
+
+var _ Engine = (*GoEngine)(nil)
+
+func (e *GoEngine) Execute(outw, errw io.Writer, in io.Reader, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	if opts != nil && opts.Stream {
+		return e.execute(outw, errw, newGoStreamIter(in), stop, opts, s)
+	}
+	return e.execute(outw, errw, newGoInputIter(in), stop, opts, s)
+}
+
+// execute applies the filter in s to the values of inputs.
+func (e *GoEngine) execute(outw, errw io.Writer, inputs gojq.Iter, stop <-chan struct{}, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	outcounter := &writeCounter{0, outw}
+	errcounter := &writeCounter{0, errw}
+	if opts == nil {
+		opts = new(JQOptions)
+	}
+	code, vals, err := e.compile(opts, s, inputs)
+	if err != nil {
+		fmt.Fprintf(errcounter, "jq: error: %v\njq: 1 compile error\n", err)
+		return outcounter.n, errcounter.n, errGoCompile
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	enc := newGoEncoder(opts)
+	run := func(v interface{}) (halt bool, err error) {
+		iter := code.RunWithContext(ctx, v, vals...)
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				return false, nil
+			}
+			if herr, ok := v.(*gojq.HaltError); ok {
+				if v := herr.Value(); v != nil {
+					if str, ok := v.(string); ok {
+						io.WriteString(errcounter, str)
+					} else {
+						bs, _ := gojq.Marshal(v)
+						fmt.Fprintf(errcounter, "%s\n", bs)
+					}
+				}
+				if herr.ExitCode() != 0 {
+					return true, herr
+				}
+				return true, nil
+			}
+			if err, ok := v.(error); ok {
+				if ctx.Err() != nil {
+					return true, nil
+				}
+				fmt.Fprintf(errcounter, "jq: error: %v\n", err)
+				return false, errGoFilter
+			}
+			err := enc.encode(outcounter, v)
+			if err != nil {
+				return true, err
+			}
+		}
+	}
+
+	var ferr error
+	switch {
+	case opts.NullInput:
+		_, ferr = run(nil)
+	case opts.Slurp:
+		var values []interface{}
+		for {
+			v, ok := inputs.Next()
+			if !ok {
+				break
+			}
+			if err, ok := v.(error); ok {
+				fmt.Fprintf(errcounter, "jq: error: %v\n", err)
+				return outcounter.n, errcounter.n, errGoFilter
+			}
+			values = append(values, v)
+		}
+		if values == nil {
+			values = []interface{}{}
+		}
+		_, ferr = run(values)
+	default:
+		for ctx.Err() == nil {
+			v, ok := inputs.Next()
+			if !ok {
+				break
+			}
+			if err, ok := v.(error); ok {
+				fmt.Fprintf(errcounter, "jq: error: %v\n", err)
+				ferr = errGoFilter
+				break
+			}
+			halt, err := run(v)
+			if err != nil {
+				ferr = err
+			}
+			if halt {
+				break
+			}
+		}
+	}
+	return outcounter.n, errcounter.n, ferr
+}
+
+// Validate parses and compiles the filter without running it.
+func (e *GoEngine) Validate(opts *JQOptions, s *JQStack, stop <-chan struct{}) error {
+	if opts == nil {
+		opts = new(JQOptions)
+	}
+	_, _, err := e.compile(opts, s, newGoInputIter(new(bytes.Buffer)))
+	return err
+}
+
+// compile compiles the program for s and returns the values of the variables
+// bound in opts.
+func (e *GoEngine) compile(opts *JQOptions, s *JQStack, inputs gojq.Iter) (*gojq.Code, []interface{}, error) {
+	program := opts.Program(JoinFilter(s))
+	q, err := gojq.Parse(program)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts = opts.bindVars(program)
+	var names []string
+	var vals []interface{}
+	for _, v := range opts.Vars {
+		val, err := goVarValue(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("$%s: %v", v.Name, err)
+		}
+		names = append(names, "$"+v.Name)
+		vals = append(vals, val)
+	}
+	copts := []gojq.CompilerOption{
+		gojq.WithVariables(names),
+		gojq.WithEnvironLoader(os.Environ),
+		gojq.WithInputIter(inputs),
+	}
+	if opts.LibPath != "" {
+		copts = append(copts, gojq.WithModuleLoader(gojq.NewModuleLoader(filepath.SplitList(opts.LibPath))))
+	}
+	code, err := gojq.Compile(q, copts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return code, vals, nil
+}
+
+// goVarValue returns the value jq binds to v.
+func goVarValue(v JQVar) (interface{}, error) {
+	switch v.Kind {
+	case VarString:
+		return v.Value, nil
+	case VarJSON:
+		values, err := decodeGoValues(strings.NewReader(v.Value))
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("invalid JSON text")
+		}
+		return values[0], nil
+	case VarFile:
+		bs, err := ioutil.ReadFile(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return decodeGoValues(bytes.NewReader(bs))
+	default:
+		return nil, fmt.Errorf("unknown kind %q", v.Kind)
+	}
+}
+
+// decodeGoValues decodes all the JSON values read from r.
+func decodeGoValues(r io.Reader) ([]interface{}, error) {
+	values := []interface{}{}
+	iter := newGoInputIter(r)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return values, nil
+		}
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+}
+
+// goInputIter is a gojq.Iter over the JSON values read from a reader.  A
+// value that cannot be decoded is returned as an error and ends iteration.
+type goInputIter struct {
+	dec  *json.Decoder
+	done bool
+}
+
+func newGoInputIter(r io.Reader) *goInputIter {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &goInputIter{dec: dec}
+}
+
+func (iter *goInputIter) Next() (interface{}, bool) {
+	if iter.done {
+		return nil, false
+	}
+	var v interface{}
+	err := iter.dec.Decode(&v)
+	if err == io.EOF {
+		iter.done = true
+		return nil, false
+	}
+	if err != nil {
+		iter.done = true
+		return err, true
+	}
+	return v, true
+}
+
+// goStreamIter is a gojq.Iter over the events jq --stream produces for the
+// JSON values read from a reader.  Values are never held in memory as a whole.
+type goStreamIter struct {
+	dec    *json.Decoder
+	frames []*goStreamFrame
+	done   bool
+}
+
+// goStreamFrame is an array or object containing the current token.
+type goStreamFrame struct {
+	object    bool
+	key       interface{} // the key or index of the current element
+	n         int         // the number of elements started
+	expectKey bool
+}
+
+func newGoStreamIter(r io.Reader) *goStreamIter {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &goStreamIter{dec: dec}
+}
+
+// path returns the path of the current element.
+func (iter *goStreamIter) path() []interface{} {
+	path := make([]interface{}, len(iter.frames))
+	for i, f := range iter.frames {
+		path[i] = f.key
+	}
+	return path
+}
+
+// begin moves the innermost container to its next element.
+func (iter *goStreamIter) begin() {
+	if len(iter.frames) == 0 {
+		return
+	}
+	f := iter.frames[len(iter.frames)-1]
+	if f.object {
+		f.expectKey = true
+	} else {
+		f.key = f.n
+	}
+	f.n++
+}
+
+func (iter *goStreamIter) Next() (interface{}, bool) {
+	for !iter.done {
+		tok, err := iter.dec.Token()
+		if err == io.EOF && len(iter.frames) == 0 {
+			iter.done = true
+			return nil, false
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			iter.done = true
+			return err, true
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			iter.begin()
+			object := tok == json.Delim('{')
+			iter.frames = append(iter.frames, &goStreamFrame{object: object, expectKey: object})
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			f := iter.frames[len(iter.frames)-1]
+			iter.frames = iter.frames[:len(iter.frames)-1]
+			if f.n > 0 {
+				// the closing event holds the path of the last element.
+				return []interface{}{append(iter.path(), f.key)}, true
+			}
+			if f.object {
+				return []interface{}{iter.path(), map[string]interface{}{}}, true
+			}
+			return []interface{}{iter.path(), []interface{}{}}, true
+		}
+		if n := len(iter.frames); n > 0 && iter.frames[n-1].expectKey {
+			f := iter.frames[n-1]
+			f.key = tok
+			f.expectKey = false
+			continue
+		}
+		iter.begin()
+		return []interface{}{iter.path(), tok}, true
+	}
+	return nil, false
+}
+
+// goEncoder writes values formatted according to the output options of jq.
+type goEncoder struct {
+	raw    bool
+	indent string // empty for compact output
+	ascii  bool
+	buf    bytes.Buffer
+}
+
+func newGoEncoder(opts *JQOptions) *goEncoder {
+	enc := &goEncoder{raw: opts.RawOutput, ascii: opts.ASCII}
+	switch {
+	case opts.Compact:
+	case opts.Tab:
+		enc.indent = "\t"
+	case opts.Indent > 0:
+		enc.indent = strings.Repeat(" ", opts.Indent)
+	default:
+		enc.indent = "  "
+	}
+	return enc
+}
+
+func (enc *goEncoder) encode(w io.Writer, v interface{}) error {
+	enc.buf.Reset()
+	if str, ok := v.(string); ok && enc.raw {
+		enc.buf.WriteString(str)
+	} else {
+		bs, err := gojq.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if enc.indent != "" {
+			err = json.Indent(&enc.buf, bs, "", enc.indent)
+			if err != nil {
+				return err
+			}
+		} else {
+			enc.buf.Write(bs)
+		}
+	}
+	enc.buf.WriteByte('\n')
+	bs := enc.buf.Bytes()
+	if enc.ascii {
+		bs = escapeNonASCII(bs)
+	}
+	_, err := w.Write(bs)
+	return err
+}
+
+// escapeNonASCII replaces runes outside the ASCII range in JSON text with
+// \u escape sequences.
+func escapeNonASCII(bs []byte) []byte {
+	var out bytes.Buffer
+	for _, c := range string(bs) {
+		if c < 0x80 {
+			out.WriteRune(c)
+			continue
+		}
+		if c > 0xffff {
+			r1, r2 := utf16.EncodeRune(c)
+			out.WriteString(`\u` + strconv.FormatInt(int64(r1), 16) + `\u` + strconv.FormatInt(int64(r2), 16))
+			continue
+		}
+		fmt.Fprintf(&out, `\u%04x`, c)
+	}
+	return out.Bytes()
+}
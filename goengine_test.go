@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGoEngine(t *testing.T) {
+	for i, test := range []struct {
+		filter string
+		in     string
+		opts   JQOptions
+		out    string
+		err    bool
+	}{
+		{".a", `{"a": [1, 2]}`, JQOptions{}, "[\n  1,\n  2\n]\n", false},
+		{".a", `{"a": [1, 2]}`, JQOptions{Compact: true}, "[1,2]\n", false},
+		{".a", `{"a": [1, 2]}`, JQOptions{Tab: true}, "[\n\t1,\n\t2\n]\n", false},
+		{".[]", `["x", "ÿ"]`, JQOptions{RawOutput: true}, "x\nÿ\n", false},
+		{".", `"ÿ"`, JQOptions{ASCII: true}, "\"\\u00ff\"\n", false},
+		{"length", `1 2 3`, JQOptions{Slurp: true}, "3\n", false},
+		{"$x", `1`, JQOptions{Vars: []JQVar{{Name: "x", Kind: VarJSON, Value: `{"y":2}`}}, Compact: true}, "{\"y\":2}\n", false},
+		{"f", `1`, JQOptions{Defs: []JQDef{{Text: "def f: . + 1;"}}}, "2\n", false},
+		{"1", `nope`, JQOptions{NullInput: true}, "1\n", false},
+		{".a", `[1]`, JQOptions{}, "", true},
+		{".a |", `{}`, JQOptions{}, "", true},
+		{".", `{`, JQOptions{}, "", true},
+	} {
+		s := new(JQStack)
+		s.Push(FilterString(test.filter))
+		var out, errbuf bytes.Buffer
+		_, _, err := new(GoEngine).Execute(&out, &errbuf, strings.NewReader(test.in), nil, false, &test.opts, s)
+		if test.err {
+			if err == nil {
+				t.Errorf("test %d (%q): expected an error", i, test.filter)
+			}
+			if errbuf.Len() == 0 {
+				t.Errorf("test %d (%q): no error message", i, test.filter)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d (%q): %v (%s)", i, test.filter, err, errbuf.Bytes())
+			continue
+		}
+		if out.String() != test.out {
+			t.Errorf("test %d (%q): got %q (expect %q)", i, test.filter, out.String(), test.out)
+		}
+	}
+}
+
+func TestGoEngineValidate(t *testing.T) {
+	s := new(JQStack)
+	s.Push(FilterString(".items"))
+	s.Push(FilterString(".foo"))
+	err := new(GoEngine).Validate(nil, s, nil)
+	if err != nil {
+		t.Errorf("valid filter: %v", err)
+	}
+	s.Push(FilterString("map(."))
+	err = new(GoEngine).Validate(nil, s, nil)
+	if err == nil {
+		t.Errorf("invalid filter: expected an error")
+	}
+}
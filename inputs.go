@@ -0,0 +1,177 @@
+// inputs.go
+// named inputs the shell can switch between and filters can refer to
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// namedInput is a file registered with :load -as.
+type namedInput struct {
+	file   string
+	istmp  bool // the file is deleted when the input is replaced
+	stream bool // the file was loaded with -stream
+}
+
+func (in *namedInput) remove() {
+	if in.istmp {
+		os.Remove(in.file)
+	}
+}
+
+// addInput registers file as the input called name, replacing any input with
+// the same name.  Filters refer to the values in the file as $name.
+func (jq *JQShell) addInput(name, file string, istmp, stream bool) {
+	jq.registerInput(name, file, istmp, stream)
+	jq.Options.SetVar(JQVar{Name: name, Kind: VarFile, Value: file})
+}
+
+// registerInput is like addInput but does not bind a variable.
+func (jq *JQShell) registerInput(name, file string, istmp, stream bool) {
+	if jq.inputs == nil {
+		jq.inputs = make(map[string]*namedInput)
+	}
+	if old, ok := jq.inputs[name]; ok && old.file != file {
+		old.remove()
+	}
+	jq.inputs[name] = &namedInput{file: file, istmp: istmp, stream: stream}
+}
+
+// useInput makes the named input the active input.  The filter stack is
+// unchanged.
+func (jq *JQShell) useInput(name string) error {
+	in, ok := jq.inputs[name]
+	if !ok {
+		return fmt.Errorf("unknown input %q", name)
+	}
+	_, err := os.Stat(in.file)
+	if err != nil {
+		return err
+	}
+	// the file of a named input is only deleted when the input is replaced.
+	jq.SetInputFile(in.file, false)
+	jq.using = name
+	jq.Options.Stream = in.stream
+	if !in.istmp {
+		jq.source = &InputSource{Name: name, File: in.file, Stream: in.stream}
+	}
+	return nil
+}
+
+// removeInputs forgets every named input and deletes their temporary files.
+func (jq *JQShell) removeInputs() {
+	for _, in := range jq.inputs {
+		in.remove()
+	}
+	jq.inputs = nil
+	jq.using = ""
+}
+
+// inputNames returns the names of the registered inputs in sorted order.
+func (jq *JQShell) inputNames() []string {
+	var names []string
+	for name := range jq.inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// inputSources returns the named inputs which can be restored by a session.
+func (jq *JQShell) inputSources() []*InputSource {
+	var sources []*InputSource
+	for _, name := range jq.inputNames() {
+		in := jq.inputs[name]
+		if !in.istmp {
+			sources = append(sources, &InputSource{Name: name, File: in.file, Stream: in.stream})
+		}
+	}
+	return sources
+}
+
+func cmdUse(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command use switches the input to one loaded with :load -as.")
+	flags.ArgSet("input")
+	flags.ArgDoc("input", "the name of an input listed by :inputs")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after switching input")
+	flags.Docs("The filter stack is kept so the same filter can be applied to each input.")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expects one input name")
+	}
+	err = jq.useInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdInputs(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command inputs lists the inputs loaded with :load -as.")
+	flags.Docs(
+		"The active input is marked with *.",
+		"Each named input is available to filters as a variable holding an",
+		"array of the values in its file, like :letfile.  The file is only",
+		"parsed by filters which refer to the variable.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(jq.inputs) == 0 && !jq.HasInput() {
+		fmt.Fprintln(os.Stderr, "no input")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+	if jq.using == "" && jq.HasInput() {
+		source := jq.filename
+		size := "-"
+		if source == "" {
+			source = "command"
+			if jq.source != nil {
+				source = "command: " + jq.source.Script
+			}
+		} else {
+			size = fileSize(source)
+		}
+		fmt.Fprintf(tw, "*\t-\t%s\t%s\n", size, source)
+	}
+	for _, name := range jq.inputNames() {
+		in := jq.inputs[name]
+		mark := ""
+		if name == jq.using {
+			mark = "*"
+		}
+		source := in.file
+		if in.stream {
+			source += " (stream)"
+		}
+		fmt.Fprintf(tw, "%s\t$%s\t%s\t%s\n", mark, name, fileSize(in.file), source)
+	}
+	return tw.Flush()
+}
+
+// fileSize returns the size of the file at path for display.
+func fileSize(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d bytes", info.Size())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNamedInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jqsh-inputs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	ioutil.WriteFile(a, []byte(`{"n": 1}`), 0644)
+	ioutil.WriteFile(b, []byte(`{"n": 2}`), 0644)
+
+	jq := &JQShell{
+		Stack:   new(JQStack),
+		History: NewStackHistory(10),
+		Options: DefaultJQOptions(),
+		engine:  new(GoEngine),
+	}
+	jq.Options.Compact = true
+	jq.addInput("a", a, false, false)
+	jq.addInput("b", b, false, false)
+	jq.Stack.Push(FilterString(".n - $a[0].n"))
+
+	check := func(name, expect string) {
+		err := jq.useInput(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jq.Stack.Len() != 1 {
+			t.Errorf("%s: stack changed", name)
+		}
+		var out, errbuf bytes.Buffer
+		_, _, err = jq.executeInput(&out, &errbuf, nil, false, &jq.Options, jq.Stack)
+		if err != nil {
+			t.Fatalf("%s: %v (%s)", name, err, errbuf.Bytes())
+		}
+		if out.String() != expect {
+			t.Errorf("%s: got %q (expect %q)", name, out.String(), expect)
+		}
+	}
+	check("a", "0\n")
+	check("b", "1\n")
+	if err := jq.useInput("c"); err == nil {
+		t.Errorf("used an unknown input")
+	}
+
+	// named inputs and the active input are restored with a session.
+	bs, err := json.Marshal(jq.Session())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := new(Session)
+	err = json.Unmarshal(bs, sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &JQShell{Stack: new(JQStack), History: NewStackHistory(10)}
+	err = restored.Restore(sess, false)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if restored.using != "b" || restored.filename != b {
+		t.Errorf("restored input %q %q (expect %q %q)", restored.using, restored.filename, "b", b)
+	}
+	if !reflect.DeepEqual(restored.inputNames(), []string{"a", "b"}) {
+		t.Errorf("restored inputs %q", restored.inputNames())
+	}
+	if !reflect.DeepEqual(restored.Session(), jq.Session()) {
+		t.Errorf("session %#v (expect %#v)", restored.Session(), jq.Session())
+	}
+}
+
+func TestNamedInputsReferenced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jqsh-inputs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.json")
+	ioutil.WriteFile(a, []byte(`{"n": 1}`), 0644)
+
+	engines := []Engine{new(GoEngine)}
+	if jqbin, err := LocateJQ(""); err == nil {
+		engines = append(engines, &ProcessEngine{Bin: jqbin})
+	}
+	for _, engine := range engines {
+		jq := &JQShell{
+			Stack:   new(JQStack),
+			History: NewStackHistory(10),
+			Options: DefaultJQOptions(),
+			engine:  engine,
+		}
+		jq.Options.Compact = true
+		jq.addInput("a", a, false, false)
+		// the file of an input is only read by filters which refer to it.
+		jq.addInput("gone", filepath.Join(dir, "missing.json"), false, false)
+		jq.useInput("a")
+		for _, test := range []struct {
+			filter string
+			expect string
+			err    bool
+		}{
+			{".n", "1\n", false},
+			{".n + $a[0].n", "2\n", false},
+			{"$gone", "", true},
+			{"$ARGS.named | length", "", true},
+			{"[$a, $gone_too] | length", "", true},
+		} {
+			s := new(JQStack)
+			s.Push(FilterString(test.filter))
+			var out, errbuf bytes.Buffer
+			_, _, err := jq.executeInput(&out, &errbuf, nil, false, &jq.Options, s)
+			if test.err {
+				if err == nil {
+					t.Errorf("%T %q: expected an error", engine, test.filter)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%T %q: %v (%s)", engine, test.filter, err, errbuf.Bytes())
+				continue
+			}
+			if out.String() != test.expect {
+				t.Errorf("%T %q: got %q (expect %q)", engine, test.filter, out.String(), test.expect)
+			}
+		}
+	}
+}
+
+func TestRefersToVar(t *testing.T) {
+	for i, test := range []struct {
+		program string
+		name    string
+		expect  bool
+	}{
+		{"$a", "a", true},
+		{".x + $a[0]", "a", true},
+		{"$ab", "a", false},
+		{"$ab | $a", "a", true},
+		{".a", "a", false},
+		{"$a_1", "a", false},
+	} {
+		if refersToVar(test.program, test.name) != test.expect {
+			t.Errorf("test %d (%q %q): expected %v", i, test.program, test.name, test.expect)
+		}
+	}
+}
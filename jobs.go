@@ -0,0 +1,337 @@
+// jobs.go
+// filter output written in the background
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// A Job writes filter output in the background.  Jobs are started by giving
+// the "-bg" flag to :write and :pipe.
+type Job struct {
+	ID      int
+	Command string // the command which started the job
+	Start   time.Time
+	out     *writeCounter
+	stop    chan struct{}
+	done    chan struct{}
+	end     time.Time
+	err     error
+	killed  int32
+}
+
+// Written returns the number of bytes of output the job has written.
+func (j *Job) Written() int64 {
+	return atomic.LoadInt64(&j.out.n)
+}
+
+// Done returns true if the job has finished.
+func (j *Job) Done() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Elapsed returns the time the job has been running, or the time it ran if it
+// has finished.
+func (j *Job) Elapsed() time.Duration {
+	if j.Done() {
+		return j.end.Sub(j.Start)
+	}
+	return time.Since(j.Start)
+}
+
+// Err returns the error the job finished with.  Err returns nil if the job
+// has not finished.
+func (j *Job) Err() error {
+	if !j.Done() {
+		return nil
+	}
+	return j.err
+}
+
+// Kill stops the job.  It has no effect if the job has finished.
+func (j *Job) Kill() {
+	if j.Done() {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&j.killed, 0, 1) {
+		close(j.stop)
+	}
+}
+
+// Status returns a short description of the job's state.
+func (j *Job) Status() string {
+	switch {
+	case !j.Done():
+		return "running"
+	case atomic.LoadInt32(&j.killed) != 0:
+		return "killed"
+	case j.err != nil:
+		return "failed"
+	default:
+		return "done"
+	}
+}
+
+func (j *Job) String() string {
+	s := fmt.Sprintf("[%d] %s  %s (%d bytes, %v)", j.ID, j.Status(), j.Command, j.Written(), roundDuration(j.Elapsed()))
+	if err := j.Err(); err != nil && j.Status() == "failed" {
+		s += ": " + err.Error()
+	}
+	return s
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d - d%(100*time.Millisecond)
+}
+
+// JobTable holds the jobs running in the background and those which have
+// finished but not been reported.  The zero value is an empty table.
+type JobTable struct {
+	mut  sync.Mutex
+	last int
+	jobs map[int]*Job
+}
+
+// Start runs fn in the background as a new job writing to w.  fn must return
+// when stop is closed.
+func (t *JobTable) Start(command string, w io.Writer, fn func(w io.Writer, stop <-chan struct{}) error) *Job {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.jobs == nil {
+		t.jobs = make(map[int]*Job)
+	}
+	t.last++
+	job := &Job{
+		ID:      t.last,
+		Command: command,
+		Start:   time.Now(),
+		out:     &writeCounter{0, w},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	t.jobs[job.ID] = job
+	go func() {
+		job.err = fn(job.out, job.stop)
+		job.end = time.Now()
+		close(job.done)
+	}()
+	return job
+}
+
+// Get returns the job with the given id.
+func (t *JobTable) Get(id int) (*Job, bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	job, ok := t.jobs[id]
+	return job, ok
+}
+
+// Remove removes a job from the table.
+func (t *JobTable) Remove(id int) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	delete(t.jobs, id)
+}
+
+// Jobs returns the jobs in the table ordered by id.
+func (t *JobTable) Jobs() []*Job {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	jobs := make([]*Job, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Sort(jobsByID(jobs))
+	return jobs
+}
+
+// Finished removes the jobs which have finished from the table and returns
+// them ordered by id.
+func (t *JobTable) Finished() []*Job {
+	var done []*Job
+	for _, job := range t.Jobs() {
+		if job.Done() {
+			t.Remove(job.ID)
+			done = append(done, job)
+		}
+	}
+	return done
+}
+
+// KillAll kills every running job and waits for them to finish.
+func (t *JobTable) KillAll() {
+	for _, job := range t.Jobs() {
+		job.Kill()
+		<-job.done
+	}
+}
+
+type jobsByID []*Job
+
+func (jobs jobsByID) Len() int           { return len(jobs) }
+func (jobs jobsByID) Less(i, j int) bool { return jobs[i].ID < jobs[j].ID }
+func (jobs jobsByID) Swap(i, j int)      { jobs[i], jobs[j] = jobs[j], jobs[i] }
+
+// startJob writes the output of the current filter to w in the background.
+// The job uses a copy of the filter stack and options so later commands do
+// not affect it.  After jq exits w is closed and wait, if not nil, is called.
+// A jq process runs in its own process group so Ctrl-C at the prompt does not
+// kill it.
+func (jq *JQShell) startJob(command string, w io.WriteCloser, wait func() error) (*Job, error) {
+	r, err := jq.Input()
+	if err != nil {
+		return nil, err
+	}
+	stack := new(JQStack)
+	stack.SetFilters(jq.Stack.Filters())
+	opts := jq.Options
+	engine := background(jq.engine)
+	job := jq.jobs.Start(command, w, func(out io.Writer, stop <-chan struct{}) error {
+		defer r.Close()
+		_, _, err := engine.Execute(out, os.Stderr, r, stop, false, &opts, stack)
+		if err != nil {
+			err = ExecError{[]string{"jq"}, err}
+		}
+		cerr := w.Close()
+		if wait != nil {
+			werr := wait()
+			if err == nil {
+				err = werr
+			}
+		}
+		if err == nil {
+			err = cerr
+		}
+		return err
+	})
+	return job, nil
+}
+
+// reportJobs logs the jobs which have finished since they were last
+// reported.
+func (jq *JQShell) reportJobs() {
+	for _, job := range jq.jobs.Finished() {
+		jq.Log.Print(job)
+	}
+}
+
+// parseJobs returns the jobs identified by args, or every job if args is
+// empty.
+func (jq *JQShell) parseJobs(args []string) ([]*Job, error) {
+	if len(args) == 0 {
+		return jq.jobs.Jobs(), nil
+	}
+	var jobs []*Job
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job %q", arg)
+		}
+		job, ok := jq.jobs.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("no job %d", id)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func cmdJobs(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command jobs lists the jobs running in the background.")
+	flags.Docs(
+		"Jobs are started by giving the -bg flag to :write or :pipe -out.",
+		"Finished jobs are reported before the next prompt.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	jobs := jq.jobs.Jobs()
+	if len(jobs) == 0 {
+		fmt.Fprintln(os.Stderr, "no jobs")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "[%d]\t%s\t%v\t%d bytes\t%s\n", job.ID, job.Status(), roundDuration(job.Elapsed()), job.Written(), job.Command)
+	}
+	return tw.Flush()
+}
+
+func cmdWait(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command wait waits for background jobs to finish.")
+	flags.ArgSet("[job]", "...")
+	flags.ArgDoc("job", "a job number as printed by :jobs (all jobs if omitted)")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	jobs, err := jq.parseJobs(flags.Args())
+	if err != nil {
+		return err
+	}
+	var failed bool
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		case <-jq.interrupted():
+			return ErrInterrupted
+		}
+		jq.jobs.Remove(job.ID)
+		jq.Log.Print(job)
+		if job.Err() != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("a job failed")
+	}
+	return nil
+}
+
+func cmdKill(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command kill stops background jobs.")
+	flags.ArgSet("job", "...")
+	flags.ArgDoc("job", "a job number as printed by :jobs")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects a job number")
+	}
+	jobs, err := jq.parseJobs(flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		job.Kill()
+		<-job.done
+		jq.jobs.Remove(job.ID)
+		jq.Log.Print(job)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestJobTable(t *testing.T) {
+	var table JobTable
+	release := make(chan struct{})
+	job1 := table.Start("one", ioutil.Discard, func(w io.Writer, stop <-chan struct{}) error {
+		io.WriteString(w, "hello")
+		<-release
+		return nil
+	})
+	job2 := table.Start("two", ioutil.Discard, func(w io.Writer, stop <-chan struct{}) error {
+		<-stop
+		return fmt.Errorf("stopped")
+	})
+	if job1.ID != 1 || job2.ID != 2 {
+		t.Fatalf("ids %d %d (expect 1 2)", job1.ID, job2.ID)
+	}
+	if len(table.Finished()) != 0 {
+		t.Errorf("jobs finished before they were stopped")
+	}
+
+	close(release)
+	<-job1.done
+	if job1.Status() != "done" {
+		t.Errorf("status %q (expect %q)", job1.Status(), "done")
+	}
+	if job1.Written() != 5 {
+		t.Errorf("%d bytes written (expect 5)", job1.Written())
+	}
+	job2.Kill()
+	<-job2.done
+	if job2.Status() != "killed" {
+		t.Errorf("status %q (expect %q)", job2.Status(), "killed")
+	}
+
+	done := table.Finished()
+	if len(done) != 2 || done[0] != job1 || done[1] != job2 {
+		t.Errorf("finished %v", done)
+	}
+	if len(table.Jobs()) != 0 {
+		t.Errorf("finished jobs remain in the table")
+	}
+}
@@ -186,18 +186,32 @@ func (w *writeCounter) Write(bs []byte) (int, error) {
 	return n, err
 }
 
-func Execute(outw, errw io.Writer, in io.Reader, stop <-chan struct{}, jq string, color bool, s *JQStack) (int64, int64, error) {
+// Execute runs jq with the filter in s, preceded by any definitions in opts,
+// and the flags corresponding to opts.  opts may be nil.
+func Execute(outw, errw io.Writer, in io.Reader, stop <-chan struct{}, jq string, color bool, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	return runCommand(jqCommand(jq, color, opts, s), outw, errw, in, stop)
+}
+
+// jqCommand returns the command Execute runs.
+func jqCommand(jq string, color bool, opts *JQOptions, s *JQStack) *exec.Cmd {
 	if jq == "" {
 		jq = "jq"
 	}
-	outcounter := &writeCounter{0, outw}
-	errcounter := &writeCounter{0, errw}
-	var args []string
+	program := opts.Program(JoinFilter(s))
+	args := opts.bindVars(program).Args()
 	if color {
 		args = append(args, "--color-output")
 	}
-	args = append(args, JoinFilter(s))
-	cmd := exec.Command(jq, args...)
+	args = append(args, program)
+	return exec.Command(jq, args...)
+}
+
+// runCommand runs cmd reading in and writing to outw and errw, returning the
+// number of bytes written to each.  The process is killed if stop is closed
+// before it exits.
+func runCommand(cmd *exec.Cmd, outw, errw io.Writer, in io.Reader, stop <-chan struct{}) (int64, int64, error) {
+	outcounter := &writeCounter{0, outw}
+	errcounter := &writeCounter{0, errw}
 	cmd.Stdin = in
 	cmd.Stdout = outcounter
 	cmd.Stderr = errcounter
@@ -260,10 +274,105 @@ func (s *JQStack) JQFilter() []string {
 	return args
 }
 
+// Len returns the number of filters on the stack.
+func (s *JQStack) Len() int {
+	return len(s.pipe)
+}
+
+// Filters returns a copy of the filters on the stack, from the bottom of the
+// stack to the top.
+func (s *JQStack) Filters() []Filter {
+	filters := make([]Filter, len(s.pipe))
+	copy(filters, s.pipe)
+	return filters
+}
+
+// SetFilters replaces the contents of the stack with filters.
+func (s *JQStack) SetFilters(filters []Filter) {
+	s.PopAll()
+	s.pipe = append(s.pipe, filters...)
+}
+
 func (s *JQStack) Push(cmd Filter) {
 	s.pipe = append(s.pipe, cmd)
 }
 
+func (s *JQStack) checkIndex(i int) error {
+	if i < 0 || i >= len(s.pipe) {
+		return fmt.Errorf("index %d out of range", i)
+	}
+	return nil
+}
+
+// Insert inserts f at index i, shifting filters at indexes greater than or
+// equal to i up the stack.  If i is the stack length Insert is equivalent to
+// Push.
+func (s *JQStack) Insert(i int, f Filter) error {
+	if i != len(s.pipe) {
+		err := s.checkIndex(i)
+		if err != nil {
+			return err
+		}
+	}
+	s.pipe = append(s.pipe, nil)
+	copy(s.pipe[i+1:], s.pipe[i:])
+	s.pipe[i] = f
+	return nil
+}
+
+// Remove removes and returns the filter at index i.
+func (s *JQStack) Remove(i int) (Filter, error) {
+	err := s.checkIndex(i)
+	if err != nil {
+		return nil, err
+	}
+	f := s.pipe[i]
+	copy(s.pipe[i:], s.pipe[i+1:])
+	s.pipe[len(s.pipe)-1] = nil
+	s.pipe = s.pipe[:len(s.pipe)-1]
+	return f, nil
+}
+
+// Replace replaces the filter at index i with f and returns the replaced
+// filter.
+func (s *JQStack) Replace(i int, f Filter) (Filter, error) {
+	err := s.checkIndex(i)
+	if err != nil {
+		return nil, err
+	}
+	old := s.pipe[i]
+	s.pipe[i] = f
+	return old, nil
+}
+
+// Swap exchanges the filters at indexes i and j.
+func (s *JQStack) Swap(i, j int) error {
+	err := s.checkIndex(i)
+	if err != nil {
+		return err
+	}
+	err = s.checkIndex(j)
+	if err != nil {
+		return err
+	}
+	s.pipe[i], s.pipe[j] = s.pipe[j], s.pipe[i]
+	return nil
+}
+
+// Move moves the filter at index i so that it is at index j, shifting the
+// filters between them.
+func (s *JQStack) Move(i, j int) error {
+	err := s.checkIndex(j)
+	if err != nil {
+		return err
+	}
+	f, err := s.Remove(i)
+	if err != nil {
+		return err
+	}
+	return s.Insert(j, f)
+}
+
 func (s *JQStack) Pop(n int) ([]Filter, error) {
 	if len(s.pipe) == 0 {
 		return nil, ErrStackEmpty
@@ -287,3 +396,86 @@ func (s *JQStack) PopAll() {
 	}
 	s.pipe = s.pipe[:0]
 }
+
+// joinFilters joins a list of filters as if they were on a stack.
+func joinFilters(filters []Filter) string {
+	s := new(JQStack)
+	s.SetFilters(filters)
+	return JoinFilter(s)
+}
+
+func filtersEqual(a, b []Filter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if JoinFilter(a[i]) != JoinFilter(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// StackHistory records previous states of a JQStack so changes to it can be
+// undone and redone.
+type StackHistory struct {
+	Max  int // the maximum number of states retained
+	undo [][]Filter
+	redo [][]Filter
+}
+
+func NewStackHistory(max int) *StackHistory {
+	return &StackHistory{Max: max}
+}
+
+// Record records that s was changed from the state prev.  Changes previously
+// undone can no longer be redone.  Nothing is recorded if s is unchanged.
+func (h *StackHistory) Record(prev []Filter, s *JQStack) {
+	if filtersEqual(prev, s.pipe) {
+		return
+	}
+	h.undo = h.push(h.undo, prev)
+	h.redo = nil
+}
+
+// Undo restores up to n previous states of s and returns the number of
+// changes undone.
+func (h *StackHistory) Undo(s *JQStack, n int) int {
+	var i int
+	for ; i < n && len(h.undo) > 0; i++ {
+		h.redo = h.push(h.redo, s.Filters())
+		s.SetFilters(h.undo[len(h.undo)-1])
+		h.undo = h.undo[:len(h.undo)-1]
+	}
+	return i
+}
+
+// Redo reapplies up to n changes reverted by Undo and returns the number of
+// changes redone.
+func (h *StackHistory) Redo(s *JQStack, n int) int {
+	var i int
+	for ; i < n && len(h.redo) > 0; i++ {
+		h.undo = h.push(h.undo, s.Filters())
+		s.SetFilters(h.redo[len(h.redo)-1])
+		h.redo = h.redo[:len(h.redo)-1]
+	}
+	return i
+}
+
+// Undos returns the states restored by successive calls to Undo, most
+// recent first.
+func (h *StackHistory) Undos() [][]Filter {
+	states := make([][]Filter, len(h.undo))
+	for i := range h.undo {
+		states[i] = h.undo[len(h.undo)-1-i]
+	}
+	return states
+}
+
+func (h *StackHistory) push(states [][]Filter, state []Filter) [][]Filter {
+	states = append(states, state)
+	if h.Max > 0 && len(states) > h.Max {
+		states = states[len(states)-h.Max:]
+	}
+	return states
+}
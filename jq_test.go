@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
@@ -158,3 +161,222 @@ func TestJQStack(t *testing.T) {
 		t.Fatalf("incorrect filter stack: %v", fs)
 	}
 }
+
+func TestJQStackEdit(t *testing.T) {
+	s := new(JQStack)
+	s.Push(FilterString("a"))
+	s.Push(FilterString("b"))
+	s.Push(FilterString("c"))
+	check := func(op string, expect ...string) {
+		fs := s.JQFilter()
+		if !reflect.DeepEqual(fs, expect) {
+			t.Fatalf("%s: incorrect filter stack: %v (expect %v)", op, fs, expect)
+		}
+	}
+
+	if err := s.Insert(1, FilterString("x")); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	check("insert", "a", "x", "b", "c")
+	if err := s.Insert(4, FilterString("y")); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	check("insert at top", "a", "x", "b", "c", "y")
+	if f, err := s.Remove(4); err != nil || JoinFilter(f) != "y" {
+		t.Fatalf("remove: %v %v", f, err)
+	}
+	check("remove", "a", "x", "b", "c")
+	if err := s.Swap(0, 3); err != nil {
+		t.Fatalf("swap: %v", err)
+	}
+	check("swap", "c", "x", "b", "a")
+	if err := s.Move(0, 2); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	check("move up", "x", "b", "c", "a")
+	if err := s.Move(3, 0); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	check("move down", "a", "x", "b", "c")
+	if f, err := s.Replace(1, FilterString("z")); err != nil || JoinFilter(f) != "x" {
+		t.Fatalf("replace: %v %v", f, err)
+	}
+	check("replace", "a", "z", "b", "c")
+
+	for _, err := range []error{
+		s.Insert(5, FilterString("x")),
+		s.Swap(0, 4),
+		s.Move(-1, 0),
+		s.Move(0, 4),
+	} {
+		if err == nil {
+			t.Errorf("expected an index error")
+		}
+	}
+	if _, err := s.Remove(4); err == nil {
+		t.Errorf("expected an index error")
+	}
+	check("invalid operations", "a", "z", "b", "c")
+}
+
+func TestStackHistory(t *testing.T) {
+	s := new(JQStack)
+	h := NewStackHistory(2)
+	push := func(f string) {
+		prev := s.Filters()
+		s.Push(FilterString(f))
+		h.Record(prev, s)
+	}
+	check := func(op string, expect string) {
+		if filter := JoinFilter(s); filter != expect {
+			t.Fatalf("%s: incorrect filter %q (expect %q)", op, filter, expect)
+		}
+	}
+
+	push("a")
+	push("b")
+	push("c")
+	h.Record(s.Filters(), s) // unchanged
+	if n := h.Undo(s, 1); n != 1 {
+		t.Fatalf("undo returned %d", n)
+	}
+	check("undo", "a | b")
+	if n := h.Undo(s, 5); n != 1 {
+		t.Fatalf("undo past the maximum history returned %d", n)
+	}
+	check("undo", "a")
+	if n := h.Redo(s, 5); n != 2 {
+		t.Fatalf("redo returned %d", n)
+	}
+	check("redo", "a | b | c")
+	h.Undo(s, 1)
+	push("d")
+	if n := h.Redo(s, 1); n != 0 {
+		t.Fatalf("redo after a change returned %d", n)
+	}
+	check("redo after a change", "a | b | d")
+	undos := h.Undos()
+	if len(undos) != 2 || joinFilters(undos[0]) != "a | b" || joinFilters(undos[1]) != "a" {
+		t.Fatalf("unexpected undo states: %v", undos)
+	}
+}
+
+func TestWriteInterrupted(t *testing.T) {
+	jqbin, err := LocateJQ("")
+	if err != nil {
+		t.Skipf("unable to find jq in PATH: %v", err)
+	}
+	jq := &JQShell{
+		Stack:   new(JQStack),
+		Options: DefaultJQOptions(),
+		engine:  &ProcessEngine{Bin: jqbin},
+		intr:    make(chan struct{}),
+	}
+	// the input never ends so jq runs until it is killed.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	jq.SetInput(func() (io.ReadCloser, error) { return pr, nil })
+	close(jq.intr)
+	_, _, err = cmdWrite_io(jq, nopWriteCloser{ioutil.Discard}, false, nil)
+	if !isInterrupted(err) {
+		t.Errorf("error %v (expect %v)", err, ErrInterrupted)
+	}
+}
+
+func TestTestFilterSample(t *testing.T) {
+	jqbin, err := LocateJQ("")
+	if err != nil {
+		t.Skipf("unable to find jq in PATH: %v", err)
+	}
+	jq := &JQShell{
+		Stack:   new(JQStack),
+		Options: DefaultJQOptions(),
+		engine:  &ProcessEngine{Bin: jqbin},
+	}
+	jq.SetInputFile("example.json", false)
+	jq.Stack.Push(FilterString(".items"))
+	jq.Stack.Push(FilterString(".foo"))
+
+	// indexing an array with a string is only an error when jq sees the data.
+	err = testFilter(jq)
+	if err != nil {
+		t.Errorf("empty validation: %v", err)
+	}
+	jq.Options.Validate = ValidateSample
+	err = testFilter(jq)
+	if err == nil {
+		t.Errorf("sample validation: expected an error")
+	}
+	jq.Stack.Pop(1)
+	err = testFilter(jq)
+	if err != nil {
+		t.Errorf("sample validation: %v", err)
+	}
+}
+
+// benchmarkShell writes a large input file and returns a shell reading it
+// with engine.
+func benchmarkShell(b *testing.B, engine Engine) *JQShell {
+	f, err := ioutil.TempFile("", "jqsh-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(f, `{"id": %d, "name": "item %d", "tags": ["a", "b", "c"], "nested": {"x": %d.5, "y": null}}`+"\n", i, i, i)
+	}
+	err = f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	jq := &JQShell{
+		Stack:   new(JQStack),
+		Options: DefaultJQOptions(),
+		engine:  engine,
+	}
+	jq.SetInputFile(f.Name(), true)
+	jq.Stack.Push(FilterString("select(.id % 1000 == 0)"))
+	jq.Stack.Push(FilterString(".nested.x"))
+	return jq
+}
+
+func benchmarkExecute(b *testing.B, engine Engine) {
+	jq := benchmarkShell(b, engine)
+	defer jq.ClearInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := jq.executeInput(ioutil.Discard, ioutil.Discard, nil, false, &jq.Options, jq.Stack)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExecuteProcess(b *testing.B) {
+	jqbin, err := LocateJQ("")
+	if err != nil {
+		b.Skipf("unable to find jq in PATH: %v", err)
+	}
+	benchmarkExecute(b, &ProcessEngine{Bin: jqbin})
+}
+
+func BenchmarkExecuteGo(b *testing.B) {
+	benchmarkExecute(b, new(GoEngine))
+}
+
+// BenchmarkExecuteWorker measures filters applied to input the worker has
+// already parsed, as for every command after the first.
+func BenchmarkExecuteWorker(b *testing.B) {
+	jq := benchmarkShell(b, new(WorkerEngine))
+	defer jq.ClearInput()
+	_, err := jq.preparedInput(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := jq.executeInput(ioutil.Discard, ioutil.Discard, nil, false, &jq.Options, jq.Stack)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
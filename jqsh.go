@@ -38,15 +38,67 @@ colon ':' are commands, other lines are shorthand for specific commands.
 Following is a list of all shell syntax in jqsh.
 
 	:<cmd> <arg1> <arg2> ...    execute cmd with the given arguments
-	:<cmd> ... +<argN>          execute cmd with an argument containing spaces (argN)
+	:<cmd> ... +<argN>          execute cmd with the rest of the line as an argument (argN)
+	:<cmd> ... <<<term>         execute cmd with following lines up to <term> as an argument
 	.                           shorthand for ":write"
 	..                          shorthand for ":pop"
 	?<filter>                   shorthand for ":peek +<filter>"
+	def <name>: <body>;         shorthand for ":def +def <name>: <body>;"
 	<filter>                    shorthand for ":push +<filter>"
 
 Note that "." is a valid jq filter but pushing it on the filter stack lacks
 semantic value.  So "." alone on a line is used as a shorthand for ":write".
 
+Command arguments may be quoted like in a unix shell using single quotes,
+double quotes, and backslash escapes.
+
+Filters containing unclosed brackets, parentheses, braces or strings, or
+ending with a pipe "|", continue on the next line and are pushed as a single
+filter.
+
+Scripts
+
+Commands can be executed without interaction from a file given with the "-f"
+flag, or given as an argument to the "-c" flag separated by semicolons.  Files
+named on the command line are loaded before the script runs.
+
+	jqsh -f explore.jqsh input.json
+	jqsh -c ':push .a; :write out.json' input.json
+
+Scripts do not page output or write it implicitly after changing the filter
+stack, use ":write" or "." instead.  A script stops with a non-zero exit
+status after the first command that fails.  With the "-k" flag the script
+continues but the exit status is still non-zero.
+
+Piped input
+
+JSON piped to an interactive shell is copied to a temporary file which is used
+as the input, and commands are read from the terminal.  The file name "-"
+reads stdin explicitly, for scripts or along with other files.
+
+	curl -s https://api.github.com/repos/stedolan/jq/issues | jqsh
+
+Startup files
+
+Before loading any input an interactive shell executes the commands in
+"~/.jqshrc" and then ".jqshrc" in the working directory, if they exist.  Errors
+are reported with the file name and line number.  The "-norc" flag skips both
+files.  Scripts run with "-f" or "-c" do not read startup files.
+
+Engines
+
+Filters are applied by running the jq executable.  The "-engine go" flag
+applies filters inside jqsh instead, using gojq, so jq does not need to be
+installed.  Output from the go engine is never colored and object keys are
+always sorted.
+
+	jqsh -engine go input.json
+
+The "-engine worker" flag is like "-engine go" but parses an input file once
+and keeps the values in memory until the input changes.  Each command after
+the first only pays for applying its filter, which makes exploring a large
+document faster at the cost of memory.
+
 Command reference
 
 A list of commands and other interactive help topics can be found through the
@@ -62,8 +114,11 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -71,52 +126,135 @@ import (
 
 var ErrNoInput = fmt.Errorf("no input")
 var ErrStackEmpty = fmt.Errorf("the stack is empty")
+var ErrInterrupted = fmt.Errorf("interrupted")
 
 func main() {
 	printVersion := flag.Bool("version", false, "print the versions of jqsh and jq then exit")
+	session := flag.String("session", "", "restore a session file written by :save")
+	sessionExec := flag.Bool("exec", false, "run the shell command producing the input of the -session file")
+	scriptFile := flag.String("f", "", "execute the commands in a script file then exit")
+	scriptCmds := flag.String("c", "", "execute semicolon separated commands then exit")
+	keepon := flag.Bool("k", false, "continue executing a script after a command fails")
+	norc := flag.Bool("norc", false, "do not execute commands in ~/.jqshrc and ./.jqshrc")
+	libpath := flag.String("L", "", "a list of directories searched for jq modules")
+	enginename := flag.String("engine", "jq", "the engine applying filters ("+strings.Join(engineNames(), " or ")+")")
 	flag.Parse()
 	args := flag.Args()
+	script := *scriptFile != "" || *scriptCmds != ""
+	if *scriptFile != "" && *scriptCmds != "" {
+		fmt.Fprintln(os.Stderr, "flags -f and -c cannot be used together")
+		os.Exit(2)
+	}
 
 	if *printVersion {
 		fmt.Println("jqsh" + Version)
 	}
 
-	jqbin, err := LocateJQ("")
-	if err == ErrJQNotFound {
-		fmt.Fprintln(os.Stderr, "Unable to locate the jq executable. Make sure it's installed.")
-		fmt.Fprintln(os.Stderr)
-		switch runtime.GOOS {
-		case "darwin":
-			fmt.Fprintln(os.Stderr, "The easiest way to install jq on OS X is with homebrew.")
+	var jqbin string
+	if *enginename == "jq" {
+		var err error
+		jqbin, err = LocateJQ("")
+		if err == ErrJQNotFound {
+			fmt.Fprintln(os.Stderr, "Unable to locate the jq executable. Make sure it's installed.")
 			fmt.Fprintln(os.Stderr)
-			fmt.Fprintln(os.Stderr, "\tbrew install jq")
-		default:
-			fmt.Fprintln(os.Stderr, "See the jq homepage for download and install instructions")
+			switch runtime.GOOS {
+			case "darwin":
+				fmt.Fprintln(os.Stderr, "The easiest way to install jq on OS X is with homebrew.")
+				fmt.Fprintln(os.Stderr)
+				fmt.Fprintln(os.Stderr, "\tbrew install jq")
+			default:
+				fmt.Fprintln(os.Stderr, "See the jq homepage for download and install instructions")
+				fmt.Fprintln(os.Stderr)
+				fmt.Fprintln(os.Stderr, "\thttp://stedolan.github.io/jq/")
+			}
 			fmt.Fprintln(os.Stderr)
-			fmt.Fprintln(os.Stderr, "\thttp://stedolan.github.io/jq/")
+			fmt.Fprintln(os.Stderr, "Alternatively, run jqsh with -engine go to apply filters without jq.")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "locating jq:", err)
+			os.Exit(1)
+		}
+		jqvers, err := CheckJQVersion(jqbin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *printVersion {
+			fmt.Println(jqvers)
+			return
 		}
-		os.Exit(1)
-	}
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "locating jq:", err)
-		os.Exit(1)
 	}
-	jqvers, err := CheckJQVersion(jqbin)
+	engine, err := NewEngine(*enginename, jqbin)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(2)
 	}
 	if *printVersion {
-		fmt.Println(jqvers)
+		fmt.Println("engine " + *enginename)
 		return
 	}
 
+	// input piped to an interactive shell is copied to a temporary file so
+	// commands can be read from the terminal.  the file name "-" copies stdin
+	// explicitly.
+	var stdinFile string
+	readStdin := !script && len(args) == 0 && *session == "" && !isTerminal(os.Stdin.Fd())
+	for _, arg := range args {
+		if arg == "-" {
+			readStdin = true
+		}
+	}
+	if readStdin {
+		stdinFile, err = copyStdin()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "reading stdin:", err)
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+		for i := range args {
+			if args[i] == "-" {
+				args[i] = stdinFile
+			}
+		}
+	}
+	// a single file is deleted by the shell when the input changes.  the file
+	// concatenated with others is read each time the input is.
+	removeStdin := func() {
+		if stdinFile != "" && len(args) > 1 {
+			os.Remove(stdinFile)
+		}
+	}
+
 	// setup initial commands to play before reading input.  single files are
-	// loaded with :load, multple files are loaded with :pipe cat
+	// loaded with :load, multple files are loaded with :pipe cat.  files
+	// given with a session replace its input but keep its filter stack.
+	// scripts write output explicitly so the input is loaded quietly.
 	var initcmds [][]string
+	var keep []string
+	if *libpath != "" {
+		initcmds = append(initcmds, []string{"set", "-q", "libpath", *libpath})
+	}
+	if script {
+		keep = append(keep, "-q")
+	}
+	if *session != "" {
+		cmd := []string{"restore"}
+		if len(args) > 0 || script {
+			cmd = append(cmd, "-q")
+		}
+		if *sessionExec {
+			cmd = append(cmd, "-exec")
+		}
+		initcmds = append(initcmds, append(cmd, *session))
+		keep = append(keep, "-k")
+	}
 	doexec := func(cache bool, script string) {
-		cmd := make([]string, 0, 3+len(args))
+		cmd := make([]string, 0, 5+len(args))
 		cmd = append(cmd, "pipe")
+		cmd = append(cmd, keep...)
 		if !cache {
 			cmd = append(cmd, "-c")
 		}
@@ -125,9 +263,11 @@ func main() {
 	}
 	switch {
 	case len(args) == 1:
-		initcmds = [][]string{
-			{"load", args[0]},
+		cmd := append([]string{"load"}, keep...)
+		if args[0] == stdinFile {
+			cmd = append(cmd, "-rm")
 		}
+		initcmds = append(initcmds, append(cmd, args[0]))
 	case len(args) > 1:
 		// TODO fix filename escaping. probably by wrapping cat in a bash
 		// script and doing proper quote escaping. this method should work ok
@@ -135,6 +275,44 @@ func main() {
 		doexec(false, fmt.Sprintf("cat %s", strings.Trim(fmt.Sprintf("%q", args), "[]")))
 	}
 
+	// run a script without interaction.  the exit status is non-zero if any
+	// command failed.  errors have already been logged.
+	if script {
+		var r io.Reader
+		if *scriptFile != "" {
+			f, err := os.Open(*scriptFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			r = f
+		} else {
+			r = strings.NewReader(strings.Join(splitScript(*scriptCmds), "\n"))
+		}
+		sh := NewInitShellReader(r, "", initcmds)
+		sh.SetName(*scriptFile)
+		err = NewScriptJQShell(engine, sh, *keepon).Wait()
+		removeStdin()
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// stdin has been read, commands come from the terminal.
+	if stdinFile != "" {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			os.Remove(stdinFile)
+			fmt.Fprintln(os.Stderr, "opening the terminal:", err)
+			fmt.Fprintln(os.Stderr, "Use -f or -c to run commands without a terminal.")
+			os.Exit(1)
+		}
+		defer tty.Close()
+		os.Stdin = tty
+	}
+
 	// create a shell environment and wait for it to receive EOF or a 'quit'
 	// command.
 	fmt.Println("Welcome to jqsh!")
@@ -146,44 +324,145 @@ func main() {
 	fmt.Println("\thttps://github.com/bmatsuo/jqsh#getting-started")
 	fmt.Println()
 	sh := NewInitShellReader(nil, "> ", initcmds)
-	jq := NewJQShell(jqbin, sh)
+	if !*norc {
+		for _, path := range rcFiles() {
+			f, err := os.Open(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			defer f.Close()
+			sh.AddScript(path, f)
+		}
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		err := sh.SetHistoryFile(filepath.Join(home, ".jqsh_history"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "reading history:", err)
+		}
+	}
+	jq := NewJQShell(engine, sh)
 	err = jq.Wait()
+	removeStdin()
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// rcFiles returns the paths of the files whose commands are executed when an
+// interactive shell starts, ~/.jqshrc followed by .jqshrc in the working
+// directory.  A file is only listed once.
+func rcFiles() []string {
+	var paths []string
+	if home := os.Getenv("HOME"); home != "" {
+		paths = append(paths, filepath.Join(home, ".jqshrc"))
+	}
+	local, err := filepath.Abs(".jqshrc")
+	if err != nil {
+		return paths
+	}
+	for _, path := range paths {
+		if path == local {
+			return paths
+		}
+	}
+	return append(paths, local)
+}
+
+// copyStdin copies stdin to a temporary file and returns the file's name.
+func copyStdin() (string, error) {
+	f, err := ioutil.TempFile("", "jqsh-stdin-")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, os.Stdin)
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
 type InvalidCommandError struct {
 	Message string
+	Column  int // the 1-based column of the error, 0 if unknown
 }
 
 func (err InvalidCommandError) Error() string {
+	if err.Column > 0 {
+		return fmt.Sprintf("column %d: %s", err.Column, err.Message)
+	}
 	return err.Message
 }
 
 type JQShell struct {
 	Log      *log.Logger
 	Stack    *JQStack
-	bin      string
+	History  *StackHistory
+	Options  JQOptions
+	engine   Engine
 	inputfn  func() (io.ReadCloser, error)
 	filename string
 	istmp    bool // the filename at path should be deleted when changed
+	inputgen int  // incremented each time the input changes
+	source   *InputSource
 	lib      *Lib
 	sh       ShellReader
+	paths    map[string]*pathCompletion
+	marks    map[string][]Filter
+	jobs     JobTable
+	cache    StackCache
+	prepared PreparedInput // the input parsed by the engine
+	inputs   map[string]*namedInput
+	using    string        // the name of the input loaded with -as, if it is active
+	script   bool          // commands are read from a script, not a user
+	keepon   bool          // continue a script after a command fails
+	intr     chan struct{} // closed when the running command is interrupted
 	err      error
 	wg       sync.WaitGroup
 }
 
-func NewJQShell(bin string, sh ShellReader) *JQShell {
+// NewJQShell starts an interactive shell reading commands from sh and
+// applying filters with engine.
+func NewJQShell(engine Engine, sh ShellReader) *JQShell {
+	jq := newJQShell(engine, sh)
+	jq.wg.Add(1)
+	go jq.loop()
+	return jq
+}
+
+// NewScriptJQShell starts a shell executing the commands in a script read
+// from sh.  Filter output is not paged and commands changing the filter stack
+// do not write its output.  The shell stops after the first command that
+// fails unless keepon is true.  Wait returns an error if any command failed.
+func NewScriptJQShell(engine Engine, sh ShellReader, keepon bool) *JQShell {
+	jq := newJQShell(engine, sh)
+	jq.script = true
+	jq.keepon = keepon
+	jq.wg.Add(1)
+	go jq.loop()
+	return jq
+}
+
+func newJQShell(engine Engine, sh ShellReader) *JQShell {
 	if sh == nil {
 		sh = NewShellReader(nil, "> ")
 	}
 	st := new(JQStack)
 	jq := &JQShell{
-		Log:   log.New(os.Stderr, "jqsh: ", 0),
-		Stack: st,
-		bin:   bin,
-		sh:    sh,
+		Log:     log.New(os.Stderr, "jqsh: ", 0),
+		Stack:   st,
+		History: NewStackHistory(100),
+		Options: DefaultJQOptions(),
+		engine:  engine,
+		sh:      sh,
 	}
 	jq.lib = Library(&DocOpt{
 		Indent:    "  ",
@@ -194,19 +473,53 @@ func NewJQShell(bin string, sh ShellReader) *JQShell {
 	jq.lib.Register("peek", JQShellCommandFunc(cmdPeek))
 	jq.lib.Register("pop", JQShellCommandFunc(cmdPop))
 	jq.lib.Register("popall", JQShellCommandFunc(cmdPopAll))
+	jq.lib.Register("edit", JQShellCommandFunc(cmdEdit))
+	jq.lib.Register("insert", JQShellCommandFunc(cmdInsert))
+	jq.lib.Register("rm", JQShellCommandFunc(cmdRm))
+	jq.lib.Register("swap", JQShellCommandFunc(cmdSwap))
+	jq.lib.Register("move", JQShellCommandFunc(cmdMove))
+	jq.lib.Register("undo", JQShellCommandFunc(cmdUndo))
+	jq.lib.Register("redo", JQShellCommandFunc(cmdRedo))
+	jq.lib.Register("mark", JQShellCommandFunc(cmdMark))
+	jq.lib.Register("goto", JQShellCommandFunc(cmdGoto))
+	jq.lib.Register("marks", JQShellCommandFunc(cmdMarks))
+	jq.lib.Register("save", JQShellCommandFunc(cmdSave))
+	jq.lib.Register("restore", JQShellCommandFunc(cmdRestore))
+	jq.lib.Register("set", JQShellCommandFunc(cmdSet))
+	jq.lib.Register("unset", JQShellCommandFunc(cmdUnset))
+	jq.lib.Register("show", JQShellCommandFunc(cmdShow))
+	jq.lib.Register("let", cmdLetKind(VarString))
+	jq.lib.Register("letjson", cmdLetKind(VarJSON))
+	jq.lib.Register("letfile", cmdLetKind(VarFile))
+	jq.lib.Register("unlet", JQShellCommandFunc(cmdUnlet))
+	jq.lib.Register("vars", JQShellCommandFunc(cmdVars))
+	jq.lib.Register("def", JQShellCommandFunc(cmdDef))
+	jq.lib.Register("defs", JQShellCommandFunc(cmdDefs))
+	jq.lib.Register("undef", JQShellCommandFunc(cmdUndef))
+	jq.lib.Register("alias", JQShellCommandFunc(cmdAlias))
+	jq.lib.Register("unalias", JQShellCommandFunc(cmdUnalias))
 	jq.lib.Register("filter", JQShellCommandFunc(cmdFilter))
 	jq.lib.Register("script", JQShellCommandFunc(cmdScript))
 	jq.lib.Register("load", JQShellCommandFunc(cmdLoad))
 	jq.lib.Register("pipe", JQShellCommandFunc(cmdPipe))
+	jq.lib.Register("use", JQShellCommandFunc(cmdUse))
+	jq.lib.Register("inputs", JQShellCommandFunc(cmdInputs))
+	jq.lib.Register("fromstream", JQShellCommandFunc(cmdFromstream))
+	jq.lib.Register("truncate", JQShellCommandFunc(cmdTruncate))
 	jq.lib.Register("write", JQShellCommandFunc(cmdWrite))
+	jq.lib.Register("jobs", JQShellCommandFunc(cmdJobs))
+	jq.lib.Register("wait", JQShellCommandFunc(cmdWait))
+	jq.lib.Register("kill", JQShellCommandFunc(cmdKill))
 	jq.lib.Register("raw", JQShellCommandFunc(cmdRaw))
 	jq.lib.Register("quit", JQShellCommandFunc(cmdQuit))
+	jq.lib.registerPlugins()
 	if shdoc, ok := sh.(Documented); ok {
 		jq.lib.RegisterHelp("syntax", shdoc.Documentation())
 	}
-
-	jq.wg.Add(1)
-	go jq.loop()
+	jq.lib.RegisterHelp("editing", lineEditorDocs)
+	if shc, ok := sh.(Completing); ok {
+		shc.SetCompleter(jq.Complete)
+	}
 	return jq
 }
 
@@ -242,6 +555,37 @@ func (jq *JQShell) Wait() error {
 	return jq.err
 }
 
+// paging returns true if filter output written to stdout should be paged.
+func (jq *JQShell) paging() bool {
+	return !jq.script && isTerminal(os.Stdout.Fd())
+}
+
+// writeImplicit writes the filter output after a command changes the stack or
+// input.  Scripts must write output explicitly.
+func (jq *JQShell) writeImplicit() error {
+	if jq.script {
+		return nil
+	}
+	return cmdWrite(jq, Flags("write", nil))
+}
+
+// interrupted returns a channel that is closed when the user interrupts the
+// running command with Ctrl-C.
+func (jq *JQShell) interrupted() <-chan struct{} {
+	return jq.intr
+}
+
+// isInterrupted returns true if the command producing err was interrupted.
+func isInterrupted(err error) bool {
+	if err == ErrInterrupted {
+		return true
+	}
+	if err, ok := err.(ExecError); ok {
+		return isInterrupted(err.err)
+	}
+	return false
+}
+
 func isShellExit(err error) bool {
 	if err == nil {
 		return false
@@ -256,6 +600,11 @@ func isShellExit(err error) bool {
 }
 
 func (jq *JQShell) ClearInput() {
+	jq.inputgen++
+	jq.cache.Clear()
+	jq.prepared = nil
+	jq.Options.Stream = false
+	jq.using = ""
 	if jq.inputfn != nil {
 		jq.inputfn = nil
 	}
@@ -266,6 +615,9 @@ func (jq *JQShell) ClearInput() {
 			jq.Log.Printf("removingtemporary file %v: %v", jq.filename, err)
 		}
 	}
+	jq.filename = ""
+	jq.istmp = false
+	jq.source = nil
 }
 
 func (jq *JQShell) loop() {
@@ -279,9 +631,31 @@ func (jq *JQShell) loop() {
 		err error
 	}
 	cmdch := make(chan cmdin)
+
+	// SIGINT interrupts the running command instead of killing jqsh, so
+	// processes are killed and temporary files removed.  At the prompt the
+	// line editor reads Ctrl-C as a key and clears the line.  Without a line
+	// editor the terminal discards the line and the shell prompts again.
+	sigint := make(chan os.Signal, 1)
+	signal.Notify(sigint, os.Interrupt)
+	defer signal.Stop(sigint)
+	var running chan struct{}
+
 	for {
 		select {
+		case <-sigint:
+			if running != nil {
+				close(running)
+				running = nil
+			} else if sh, ok := jq.sh.(Interruptible); ok {
+				sh.Interrupt()
+			}
 		case <-stop:
+			// jobs still running are killed when the shell exits.
+			jq.jobs.KillAll()
+			jq.cache.Clear()
+			jq.removeInputs()
+
 			// remove any temporary file
 			if jq.filename != "" && jq.istmp {
 				err := os.Remove(jq.filename)
@@ -293,16 +667,25 @@ func (jq *JQShell) loop() {
 			jq.wg.Done()
 			return
 		case <-ready:
+			running = nil
+			jq.intr = nil
+			jq.reportJobs()
 			go func() {
 				cmd, eof, err := jq.sh.ReadCommand()
 				cmdch <- cmdin{cmd, eof, err}
 			}()
 		case cmd := <-cmdch:
 			if err, ok := cmd.err.(InvalidCommandError); ok {
-				jq.Log.Println(err)
+				jq.logError(err)
+				if jq.fail(err) {
+					_stop()
+					continue
+				}
 				ready <- struct{}{}
 				continue
 			}
+			running = make(chan struct{})
+			jq.intr = running
 			go func() {
 				err := cmd.err
 				if err == io.EOF {
@@ -313,15 +696,15 @@ func (jq *JQShell) loop() {
 					_stop()
 					return
 				}
-				if cmd.eof {
-					_stop()
-					return
-				}
 				if err != nil {
-					jq.Log.Print(err)
-				} else if len(cmd.cmd) == 0 {
+					jq.logError(err)
+				} else if len(cmd.cmd) == 0 && !cmd.eof {
 					jq.Log.Println("empty command")
 				}
+				if jq.fail(err) || cmd.eof {
+					_stop()
+					return
+				}
 				ready <- struct{}{}
 			}()
 		}
@@ -329,6 +712,31 @@ func (jq *JQShell) loop() {
 	panic("unreachable")
 }
 
+// fail records an error from a script and returns true if the script should
+// stop.  An interrupted script always stops.  Errors do not stop an
+// interactive shell.
+func (jq *JQShell) fail(err error) bool {
+	if err == nil || !jq.script {
+		return false
+	}
+	if jq.err == nil {
+		jq.err = err
+	}
+	return !jq.keepon || isInterrupted(err)
+}
+
+// logError logs an error from the last command read, prefixed with the
+// command's position when it is known.
+func (jq *JQShell) logError(err error) {
+	if sh, ok := jq.sh.(Positioned); ok {
+		if pos := sh.Position(); pos != "" {
+			jq.Log.Printf("%s: %v", pos, err)
+			return
+		}
+	}
+	jq.Log.Print(err)
+}
+
 func (jq *JQShell) log(v ...interface{}) {
 	jq.Log.Print(v...)
 }
@@ -351,7 +759,7 @@ func (jq *JQShell) execute(cmd []string, err error) error {
 		return err
 	}
 	if err, ok := err.(InvalidCommandError); ok {
-		jq.Log.Print(err)
+		jq.logError(err)
 		return nil
 	}
 	if err != nil {
@@ -371,3 +779,35 @@ type Documented interface {
 	// be formatted according to application specific rules.
 	Documentation() string
 }
+
+// LineEditing is a type that can prompt the user to edit a line of text.
+type LineEditing interface {
+	// EditLine displays prompt and text and returns text after the user has
+	// edited it.
+	EditLine(prompt, text string) (string, error)
+}
+
+// Completing is a type that can complete partial input using a Completer.
+type Completing interface {
+	// SetCompleter sets the function used to produce completion candidates.
+	SetCompleter(Completer)
+}
+
+// Prompting is a ShellReader whose prompt can be changed.
+type Prompting interface {
+	SetPrompt(prompt string)
+}
+
+// Positioned is a ShellReader that knows where its commands were read from.
+type Positioned interface {
+	// Position returns the location of the last command read (e.g.
+	// "file:line"), or an empty string if it is unknown.
+	Position() string
+}
+
+// Interruptible is a ShellReader that responds to Ctrl-C while it waits for a
+// command.
+type Interruptible interface {
+	// Interrupt discards the line being read and prompts for it again.
+	Interrupt()
+}
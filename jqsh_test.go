@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCopyStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	input := `{"a": 1}` + "\n" + `{"a": 2}` + "\n"
+	go func() {
+		io.WriteString(w, input)
+		w.Close()
+	}()
+	path, err := copyStdin()
+	os.Stdin = stdin
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != input {
+		t.Errorf("copied %q (expect %q)", bs, input)
+	}
+
+	// the copy is deleted when the input is replaced.
+	jq := &JQShell{Stack: new(JQStack), Options: DefaultJQOptions()}
+	jq.SetInputFile(path, true)
+	jq.SetInputFile("example.json", false)
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		os.Remove(path)
+		t.Errorf("copy of stdin not deleted (%v)", err)
+	}
+}
@@ -0,0 +1,707 @@
+// lineedit.go
+// a small emacs-style line editor with history for interactive terminals
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const lineEditorDocs = `
+Topic editing describes the key bindings available at the interactive prompt.
+
+When jqsh reads commands from a terminal the prompt supports line editing and
+a history of previously entered lines.  The history is saved in the file
+~/.jqsh_history so it is available in later sessions.
+
+	Left, Ctrl-B         move the cursor back one character
+	Right, Ctrl-F        move the cursor forward one character
+	Alt-B, Alt-F         move the cursor back/forward one word
+	Home, Ctrl-A         move the cursor to the start of the line
+	End, Ctrl-E          move the cursor to the end of the line
+	Backspace, Ctrl-H    delete the character before the cursor
+	Delete               delete the character under the cursor
+	Ctrl-D               delete the character under the cursor (EOF if empty)
+	Ctrl-K               kill text from the cursor to the end of the line
+	Ctrl-U               kill text from the start of the line to the cursor
+	Ctrl-W               kill the word before the cursor
+	Alt-D                kill the word after the cursor
+	Ctrl-Y               yank the most recently killed text
+	Ctrl-T               transpose the characters around the cursor
+	Up, Ctrl-P           recall the previous history line
+	Down, Ctrl-N         recall the next history line
+	Ctrl-R               search backwards through history
+	Tab                  complete the word before the cursor (twice lists choices)
+	Ctrl-L               clear the screen
+	Ctrl-C               discard the current line
+
+During a history search (Ctrl-R) typed characters refine the search, Ctrl-R
+finds an older match, Enter accepts the match, and Ctrl-G cancels the search.
+
+While a command is running Ctrl-C interrupts it.  Any jq or shell process it
+started is killed and jqsh returns to the prompt.
+`
+
+// special keys decoded from terminal escape sequences.  they are negative so
+// they cannot collide with runes typed by the user.
+const (
+	keyUnknown rune = -(iota + 1)
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyKillWordRight
+)
+
+const (
+	ctrlA     = 'A' - '@'
+	ctrlB     = 'B' - '@'
+	ctrlC     = 'C' - '@'
+	ctrlD     = 'D' - '@'
+	ctrlE     = 'E' - '@'
+	ctrlF     = 'F' - '@'
+	ctrlG     = 'G' - '@'
+	ctrlH     = 'H' - '@'
+	ctrlK     = 'K' - '@'
+	ctrlL     = 'L' - '@'
+	ctrlN     = 'N' - '@'
+	ctrlP     = 'P' - '@'
+	ctrlR     = 'R' - '@'
+	ctrlT     = 'T' - '@'
+	ctrlU     = 'U' - '@'
+	ctrlW     = 'W' - '@'
+	ctrlY     = 'Y' - '@'
+	keyTab    = '\t'
+	keyEnter  = '\r'
+	keyNL     = '\n'
+	keyEsc    = 0x1b
+	keyDelBS  = 0x7f
+	maxCSILen = 16
+)
+
+// A Completer returns candidates to complete the word before pos in line.
+// Candidates replace the text line[start:pos] and should include any suffix
+// (e.g. a trailing space) that is to be inserted after them.
+type Completer func(line string, pos int) (start int, candidates []string)
+
+// LineEditor reads lines from a terminal, allowing the user to edit them
+// before they are submitted.
+type LineEditor struct {
+	History  *History
+	Complete Completer
+	in       *bufio.Reader
+	fd       uintptr
+	out      io.Writer
+	killbuf  []rune
+}
+
+// NewLineEditor returns a LineEditor that reads keys from in, which must be a
+// terminal, and draws the line on out.
+func NewLineEditor(in *os.File, out io.Writer) *LineEditor {
+	return &LineEditor{
+		History: NewHistory(1000),
+		in:      bufio.NewReader(in),
+		fd:      in.Fd(),
+		out:     out,
+	}
+}
+
+// ReadLine displays prompt and returns the line entered by the user.  The
+// returned line does not contain a trailing newline.  ReadLine returns io.EOF
+// if the user pressed Ctrl-D on an empty line.
+func (ed *LineEditor) ReadLine(prompt string) (string, error) {
+	return ed.EditLine(prompt, "")
+}
+
+// EditLine is like ReadLine but the line initially contains text.
+func (ed *LineEditor) EditLine(prompt, text string) (string, error) {
+	state, err := makeRaw(ed.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restoreTerm(ed.fd, state)
+
+	l := &lineState{
+		ed:     ed,
+		prompt: prompt,
+		buf:    []rune(text),
+		hist:   ed.History.Len(),
+	}
+	l.pos = len(l.buf)
+	l.refresh()
+	var lastkey rune
+	for {
+		key, err := ed.readKey()
+		if err != nil {
+			if err == io.EOF && len(l.buf) > 0 {
+				// submit the partial line.  the next read will return EOF.
+				ed.write("\r\n")
+				return l.submit(), nil
+			}
+			return "", err
+		}
+		if l.search != nil {
+			done, accept := l.searchKey(key)
+			if !done {
+				continue
+			}
+			if accept {
+				ed.write("\r\n")
+				return l.submit(), nil
+			}
+			// the key terminating the search is processed normally.
+		}
+		switch key {
+		case keyEnter, keyNL:
+			ed.write("\r\n")
+			return l.submit(), nil
+		case ctrlC:
+			ed.write("^C\r\n")
+			l.buf = l.buf[:0]
+			l.pos = 0
+			l.off = 0
+			l.hist = ed.History.Len()
+		case ctrlD:
+			if len(l.buf) == 0 {
+				return "", io.EOF
+			}
+			l.deleteRight(1)
+		case keyDelete:
+			l.deleteRight(1)
+		case ctrlH, keyDelBS:
+			l.deleteLeft(1)
+		case ctrlA, keyHome:
+			l.pos = 0
+		case ctrlE, keyEnd:
+			l.pos = len(l.buf)
+		case ctrlB, keyLeft:
+			if l.pos > 0 {
+				l.pos--
+			}
+		case ctrlF, keyRight:
+			if l.pos < len(l.buf) {
+				l.pos++
+			}
+		case keyWordLeft:
+			l.pos = l.wordLeft()
+		case keyWordRight:
+			l.pos = l.wordRight()
+		case ctrlK:
+			l.kill(l.pos, len(l.buf))
+		case ctrlU:
+			l.kill(0, l.pos)
+		case ctrlW:
+			l.kill(l.wordLeft(), l.pos)
+		case keyKillWordRight:
+			l.kill(l.pos, l.wordRight())
+		case ctrlY:
+			l.insert(ed.killbuf...)
+		case ctrlT:
+			l.transpose()
+		case ctrlP, keyUp:
+			l.recall(l.hist - 1)
+		case ctrlN, keyDown:
+			l.recall(l.hist + 1)
+		case ctrlR:
+			l.search = &searchState{match: l.hist, saved: l.buf}
+		case ctrlL:
+			ed.write("\x1b[H\x1b[2J")
+		case keyTab:
+			if ed.Complete == nil {
+				l.insert('\t')
+			} else {
+				l.complete(lastkey == keyTab)
+			}
+		default:
+			if key >= 0 && unicode.IsPrint(key) {
+				l.insert(key)
+			}
+		}
+		lastkey = key
+		l.refresh()
+	}
+}
+
+func (ed *LineEditor) write(s string) {
+	io.WriteString(ed.out, s)
+}
+
+// readKey reads a single key press, decoding the escape sequences sent by
+// common terminals for cursor movement keys.
+func (ed *LineEditor) readKey() (rune, error) {
+	c, _, err := ed.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if c != keyEsc {
+		return c, nil
+	}
+	c, _, err = ed.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case 'b', 'B':
+		return keyWordLeft, nil
+	case 'f', 'F':
+		return keyWordRight, nil
+	case 'd', 'D':
+		return keyKillWordRight, nil
+	case 'O':
+		c, _, err = ed.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		return decodeSS3(c), nil
+	case '[':
+		var seq []rune
+		for len(seq) < maxCSILen {
+			c, _, err = ed.in.ReadRune()
+			if err != nil {
+				return 0, err
+			}
+			seq = append(seq, c)
+			if c >= 0x40 && c <= 0x7e {
+				return decodeCSI(string(seq)), nil
+			}
+		}
+	}
+	return keyUnknown, nil
+}
+
+func decodeSS3(c rune) rune {
+	switch c {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	}
+	return keyUnknown
+}
+
+func decodeCSI(seq string) rune {
+	switch seq {
+	case "1~", "7~":
+		return keyHome
+	case "4~", "8~":
+		return keyEnd
+	case "3~":
+		return keyDelete
+	case "1;5C", "1;3C":
+		return keyWordRight
+	case "1;5D", "1;3D":
+		return keyWordLeft
+	}
+	if len(seq) == 1 {
+		return decodeSS3(rune(seq[0]))
+	}
+	return keyUnknown
+}
+
+// lineState is the state of a line being edited.
+type lineState struct {
+	ed     *LineEditor
+	prompt string
+	buf    []rune
+	pos    int // cursor position in buf
+	off    int // index of the first rune in buf that is displayed
+	hist   int // index of the history line being displayed
+	saved  []rune
+	search *searchState
+}
+
+type searchState struct {
+	query []rune
+	match int
+	saved []rune
+}
+
+func (l *lineState) submit() string {
+	line := string(l.buf)
+	if strings.TrimSpace(line) != "" {
+		l.ed.History.Add(line)
+	}
+	return line
+}
+
+func (l *lineState) insert(rs ...rune) {
+	buf := make([]rune, 0, len(l.buf)+len(rs))
+	buf = append(buf, l.buf[:l.pos]...)
+	buf = append(buf, rs...)
+	buf = append(buf, l.buf[l.pos:]...)
+	l.buf = buf
+	l.pos += len(rs)
+}
+
+func (l *lineState) deleteLeft(n int) {
+	if n > l.pos {
+		n = l.pos
+	}
+	l.buf = append(l.buf[:l.pos-n], l.buf[l.pos:]...)
+	l.pos -= n
+}
+
+func (l *lineState) deleteRight(n int) {
+	if l.pos+n > len(l.buf) {
+		n = len(l.buf) - l.pos
+	}
+	l.buf = append(l.buf[:l.pos], l.buf[l.pos+n:]...)
+}
+
+// kill removes the runes in buf[i:j] and saves them so they can be yanked.
+func (l *lineState) kill(i, j int) {
+	if i >= j {
+		return
+	}
+	l.ed.killbuf = append([]rune(nil), l.buf[i:j]...)
+	l.buf = append(l.buf[:i], l.buf[j:]...)
+	l.pos = i
+}
+
+func (l *lineState) transpose() {
+	if len(l.buf) < 2 || l.pos == 0 {
+		return
+	}
+	if l.pos == len(l.buf) {
+		l.pos--
+	}
+	l.buf[l.pos-1], l.buf[l.pos] = l.buf[l.pos], l.buf[l.pos-1]
+	l.pos++
+}
+
+// complete replaces the word before the cursor with the longest prefix
+// common to all completion candidates.  If the word cannot be extended and
+// list is true the candidates are printed below the line.
+func (l *lineState) complete(list bool) {
+	text := string(l.buf[:l.pos])
+	start, cands := l.ed.Complete(string(l.buf), len(text))
+	if len(cands) == 0 || start < 0 || start > len(text) {
+		l.ed.write("\a")
+		return
+	}
+	word := []rune(text[start:])
+	prefix := []rune(commonPrefix(cands))
+	if len(prefix) > len(word) || (len(cands) == 1 && string(prefix) != string(word)) {
+		i := l.pos - len(word)
+		buf := make([]rune, 0, len(l.buf)-len(word)+len(prefix))
+		buf = append(buf, l.buf[:i]...)
+		buf = append(buf, prefix...)
+		buf = append(buf, l.buf[l.pos:]...)
+		l.buf = buf
+		l.pos = i + len(prefix)
+		return
+	}
+	if len(cands) == 1 || !list {
+		l.ed.write("\a")
+		return
+	}
+	l.ed.write("\r\n")
+	l.ed.write(formatColumns(cands, terminalWidth(l.ed.fd)-1))
+}
+
+// commonPrefix returns the longest string which is a prefix of every string in
+// strs.
+func commonPrefix(strs []string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			_, n := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-n]
+		}
+	}
+	return prefix
+}
+
+// formatColumns lays out strs in columns ordered top to bottom, fitting
+// within width.  Lines are terminated with "\r\n" because the terminal is in
+// raw mode.
+func formatColumns(strs []string, width int) string {
+	colwidth := 0
+	for _, s := range strs {
+		n := utf8.RuneCountInString(strings.TrimSpace(s)) + 2
+		if n > colwidth {
+			colwidth = n
+		}
+	}
+	ncol := width / colwidth
+	if ncol < 1 {
+		ncol = 1
+	}
+	nrow := (len(strs) + ncol - 1) / ncol
+	var b bytes.Buffer
+	for row := 0; row < nrow; row++ {
+		for col := 0; col < ncol; col++ {
+			i := col*nrow + row
+			if i >= len(strs) {
+				break
+			}
+			s := strings.TrimSpace(strs[i])
+			b.WriteString(s)
+			if col < ncol-1 && i+nrow < len(strs) {
+				b.WriteString(strings.Repeat(" ", colwidth-utf8.RuneCountInString(s)))
+			}
+		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+func (l *lineState) wordLeft() int {
+	i := l.pos
+	for i > 0 && !isWordRune(l.buf[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(l.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (l *lineState) wordRight() int {
+	i := l.pos
+	for i < len(l.buf) && !isWordRune(l.buf[i]) {
+		i++
+	}
+	for i < len(l.buf) && isWordRune(l.buf[i]) {
+		i++
+	}
+	return i
+}
+
+// recall replaces the line with history line i.  The line being edited
+// before history was browsed is restored when i moves past the newest
+// history line.
+func (l *lineState) recall(i int) {
+	h := l.ed.History
+	if i < 0 || i > h.Len() {
+		return
+	}
+	if l.hist == h.Len() {
+		l.saved = append([]rune(nil), l.buf...)
+	}
+	l.hist = i
+	if i == h.Len() {
+		l.buf = l.saved
+	} else {
+		l.buf = []rune(h.At(i))
+	}
+	l.pos = len(l.buf)
+}
+
+// searchKey handles a key press during an incremental history search.  done
+// is true when the search has terminated.  accept is true if the line should
+// be submitted immediately, otherwise the terminating key should be handled
+// by the editor.
+func (l *lineState) searchKey(key rune) (done, accept bool) {
+	s := l.search
+	switch key {
+	case keyEnter, keyNL:
+		l.search = nil
+		return true, true
+	case ctrlG, ctrlC:
+		l.buf = s.saved
+		l.pos = len(l.buf)
+		l.search = nil
+		l.refresh()
+		return true, false
+	case ctrlR:
+		l.searchFrom(s.match - 1)
+	case ctrlH, keyDelBS:
+		if len(s.query) > 0 {
+			s.query = s.query[:len(s.query)-1]
+			l.searchFrom(l.hist)
+		}
+	default:
+		if key < 0 || !unicode.IsPrint(key) {
+			l.search = nil
+			return true, false
+		}
+		s.query = append(s.query, key)
+		l.searchFrom(s.match)
+	}
+	l.refresh()
+	return false, false
+}
+
+// searchFrom finds the newest history line at index i or older which
+// contains the search query.
+func (l *lineState) searchFrom(i int) {
+	s := l.search
+	h := l.ed.History
+	q := string(s.query)
+	if i >= h.Len() {
+		i = h.Len() - 1
+	}
+	for ; i >= 0; i-- {
+		line := h.At(i)
+		k := strings.Index(line, q)
+		if k >= 0 {
+			s.match = i
+			l.hist = i
+			l.buf = []rune(line)
+			l.pos = utf8.RuneCountInString(line[:k])
+			return
+		}
+	}
+}
+
+// refresh redraws the line.  Lines wider than the terminal scroll
+// horizontally to keep the cursor visible.
+func (l *lineState) refresh() {
+	prompt := l.prompt
+	if l.search != nil {
+		prompt = fmt.Sprintf("(reverse-i-search)`%s': ", string(l.search.query))
+	}
+	width := terminalWidth(l.ed.fd) - 1
+	avail := width - utf8.RuneCountInString(prompt)
+	if avail < 1 {
+		avail = 1
+	}
+	if l.pos < l.off {
+		l.off = l.pos
+	}
+	if l.pos-l.off >= avail {
+		l.off = l.pos - avail + 1
+	}
+	end := l.off + avail
+	if end > len(l.buf) {
+		end = len(l.buf)
+	}
+	var b bytes.Buffer
+	b.WriteString("\r")
+	b.WriteString(prompt)
+	for _, c := range l.buf[l.off:end] {
+		if c == '\t' {
+			c = ' '
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString("\x1b[K\r")
+	col := utf8.RuneCountInString(prompt) + l.pos - l.off
+	if col > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", col)
+	}
+	l.ed.out.Write(b.Bytes())
+}
+
+// History is a list of previously entered lines.  History can be persisted
+// to a file so it is available across sessions.
+type History struct {
+	Max   int // the maximum number of lines retained
+	lines []string
+	path  string
+}
+
+func NewHistory(max int) *History {
+	return &History{Max: max}
+}
+
+func (h *History) Len() int {
+	return len(h.lines)
+}
+
+// At returns history line i, where line 0 is the oldest line.
+func (h *History) At(i int) string {
+	return h.lines[i]
+}
+
+// Add appends line to the history.  Lines identical to the previous line are
+// not recorded.  If the history was loaded from a file the line is appended to
+// the file as well.
+func (h *History) Add(line string) {
+	if len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if h.Max > 0 && len(h.lines) > h.Max {
+		h.lines = h.lines[len(h.lines)-h.Max:]
+	}
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// Load reads history lines from the file at path and appends future lines to
+// it.  A missing file is not an error.  If the file contains more than Max
+// lines it is rewritten so that it does not grow without bound.
+func (h *History) Load(path string) error {
+	h.path = path
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	f.Close()
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	truncate := h.Max > 0 && len(lines) > h.Max
+	if truncate {
+		lines = lines[len(lines)-h.Max:]
+	}
+	h.lines = append(lines, h.lines...)
+	if truncate {
+		return h.save()
+	}
+	return nil
+}
+
+func (h *History) save() error {
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range h.lines {
+		fmt.Fprintln(w, line)
+	}
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
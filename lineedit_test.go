@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jqsh-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+	ioutil.WriteFile(path, []byte("a\n\nb\nc\nd\n"), 0600)
+
+	readFile := func() string {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+	lines := func(h *History) []string {
+		var lines []string
+		for i := 0; i < h.Len(); i++ {
+			lines = append(lines, h.At(i))
+		}
+		return lines
+	}
+
+	// a file longer than Max is truncated when it is loaded.
+	h := NewHistory(3)
+	err = h.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(h); !reflect.DeepEqual(got, []string{"b", "c", "d"}) {
+		t.Errorf("loaded %q", got)
+	}
+	if got := readFile(); got != "b\nc\nd\n" {
+		t.Errorf("truncated file %q", got)
+	}
+
+	// a line identical to the previous one is not recorded.
+	h.Add("d")
+	if got := lines(h); !reflect.DeepEqual(got, []string{"b", "c", "d"}) {
+		t.Errorf("added a duplicate: %q", got)
+	}
+	h.Add("c")
+	h.Add("e")
+	if got := lines(h); !reflect.DeepEqual(got, []string{"d", "c", "e"}) {
+		t.Errorf("added lines %q", got)
+	}
+	if got := readFile(); got != "b\nc\nd\nc\ne\n" {
+		t.Errorf("appended file %q", got)
+	}
+
+	// lines added before a file is loaded follow the lines in the file.
+	h = NewHistory(10)
+	h.Add("x")
+	err = h.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(h); !reflect.DeepEqual(got, []string{"b", "c", "d", "c", "e", "x"}) {
+		t.Errorf("loaded %q", got)
+	}
+
+	// a missing file is created when a line is added.
+	missing := filepath.Join(dir, "missing")
+	h = NewHistory(10)
+	err = h.Load(missing)
+	if err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+	h.Add("y")
+	bs, err := ioutil.ReadFile(missing)
+	if err != nil || string(bs) != "y\n" {
+		t.Errorf("created file %q (%v)", bs, err)
+	}
+}
+
+func TestDecodeKeys(t *testing.T) {
+	for i, test := range []struct {
+		seq string
+		key rune
+	}{
+		{"A", keyUp},
+		{"B", keyDown},
+		{"C", keyRight},
+		{"D", keyLeft},
+		{"H", keyHome},
+		{"F", keyEnd},
+		{"1~", keyHome},
+		{"7~", keyHome},
+		{"4~", keyEnd},
+		{"8~", keyEnd},
+		{"3~", keyDelete},
+		{"1;5C", keyWordRight},
+		{"1;3C", keyWordRight},
+		{"1;5D", keyWordLeft},
+		{"1;3D", keyWordLeft},
+		{"Z", keyUnknown},
+		{"2~", keyUnknown},
+	} {
+		key := decodeCSI(test.seq)
+		if key != test.key {
+			t.Errorf("test %d (CSI %q): got %d (expect %d)", i, test.seq, key, test.key)
+		}
+		if len(test.seq) == 1 {
+			key = decodeSS3(rune(test.seq[0]))
+			if key != test.key {
+				t.Errorf("test %d (SS3 %q): got %d (expect %d)", i, test.seq, key, test.key)
+			}
+		}
+	}
+
+	in := "a\x1b[A\x1bOH\x1b[3~\x1bb\x1bF\x1bd\x1b[1;5C\x1bx\x03"
+	expect := []rune{'a', keyUp, keyHome, keyDelete, keyWordLeft, keyWordRight, keyKillWordRight, keyWordRight, keyUnknown, ctrlC}
+	ed := &LineEditor{in: bufio.NewReader(strings.NewReader(in))}
+	var keys []rune
+	for {
+		key, err := ed.readKey()
+		if err != nil {
+			break
+		}
+		keys = append(keys, key)
+	}
+	if !reflect.DeepEqual(keys, expect) {
+		t.Errorf("read keys %d (expect %d)", keys, expect)
+	}
+}
+
+// testLineState returns a lineState editing text with the cursor at pos.
+// Nothing is drawn on a terminal.
+func testLineState(text string, pos int, history ...string) *lineState {
+	ed := &LineEditor{
+		History: NewHistory(10),
+		fd:      ^uintptr(0),
+		out:     ioutil.Discard,
+	}
+	for _, line := range history {
+		ed.History.Add(line)
+	}
+	return &lineState{
+		ed:   ed,
+		buf:  []rune(text),
+		pos:  pos,
+		hist: ed.History.Len(),
+	}
+}
+
+func TestLineStateEditing(t *testing.T) {
+	for i, test := range []struct {
+		text string
+		pos  int
+		edit func(l *lineState)
+		out  string
+		opos int
+	}{
+		{"", 0, func(l *lineState) { l.insert('a', 'b') }, "ab", 2},
+		{"ad", 1, func(l *lineState) { l.insert('b', 'c') }, "abcd", 3},
+		{"abc", 2, func(l *lineState) { l.deleteLeft(1) }, "ac", 1},
+		{"abc", 1, func(l *lineState) { l.deleteLeft(5) }, "bc", 0},
+		{"abc", 1, func(l *lineState) { l.deleteRight(1) }, "ac", 1},
+		{"abc", 2, func(l *lineState) { l.deleteRight(5) }, "ab", 2},
+		{"abc", 1, func(l *lineState) { l.transpose() }, "bac", 2},
+		{"abc", 3, func(l *lineState) { l.transpose() }, "acb", 3},
+		{"abc", 0, func(l *lineState) { l.transpose() }, "abc", 0},
+		{".items | .name", 14, func(l *lineState) { l.pos = l.wordLeft() }, ".items | .name", 10},
+		{".items | .name", 10, func(l *lineState) { l.pos = l.wordLeft() }, ".items | .name", 1},
+		{".items | .name", 0, func(l *lineState) { l.pos = l.wordRight() }, ".items | .name", 6},
+		{".items | .name", 6, func(l *lineState) { l.pos = l.wordRight() }, ".items | .name", 14},
+		{"héllo wörld", 11, func(l *lineState) { l.pos = l.wordLeft() }, "héllo wörld", 6},
+	} {
+		l := testLineState(test.text, test.pos)
+		test.edit(l)
+		if string(l.buf) != test.out || l.pos != test.opos {
+			t.Errorf("test %d (%q at %d): got %q at %d (expect %q at %d)", i, test.text, test.pos, string(l.buf), l.pos, test.out, test.opos)
+		}
+	}
+}
+
+func TestLineStateKillYank(t *testing.T) {
+	l := testLineState(".items | .name", 8)
+	l.kill(l.pos, len(l.buf)) // Ctrl-K
+	if string(l.buf) != ".items |" || string(l.ed.killbuf) != " .name" {
+		t.Errorf("kill to end: %q (killed %q)", string(l.buf), string(l.ed.killbuf))
+	}
+	l.pos = 0
+	l.insert(l.ed.killbuf...) // Ctrl-Y
+	if string(l.buf) != " .name.items |" || l.pos != 6 {
+		t.Errorf("yank: %q at %d", string(l.buf), l.pos)
+	}
+	l.kill(l.wordLeft(), l.pos) // Ctrl-W
+	if string(l.buf) != " ..items |" || string(l.ed.killbuf) != "name" || l.pos != 2 {
+		t.Errorf("kill word: %q at %d (killed %q)", string(l.buf), l.pos, string(l.ed.killbuf))
+	}
+	l.kill(0, l.pos) // Ctrl-U
+	if string(l.buf) != ".items |" || string(l.ed.killbuf) != " ." || l.pos != 0 {
+		t.Errorf("kill to start: %q at %d (killed %q)", string(l.buf), l.pos, string(l.ed.killbuf))
+	}
+	l.kill(l.pos, l.pos) // nothing to kill
+	if string(l.ed.killbuf) != " ." {
+		t.Errorf("empty kill replaced %q", string(l.ed.killbuf))
+	}
+}
+
+func TestLineStateHistory(t *testing.T) {
+	l := testLineState("draft", 5, ".a", ".b", ".c")
+	l.recall(l.hist - 1) // Up
+	l.recall(l.hist - 1)
+	if string(l.buf) != ".b" || l.pos != 2 {
+		t.Errorf("recalled %q at %d", string(l.buf), l.pos)
+	}
+	l.recall(l.hist + 1) // Down
+	l.recall(l.hist + 1)
+	if string(l.buf) != "draft" {
+		t.Errorf("restored %q", string(l.buf))
+	}
+	l.recall(l.hist + 1)
+	if string(l.buf) != "draft" || l.hist != 3 {
+		t.Errorf("moved past the newest line: %q %d", string(l.buf), l.hist)
+	}
+}
+
+func TestLineStateSearch(t *testing.T) {
+	l := testLineState("draft", 5, ".items[]", ".name", ".items | length", ".type")
+	l.search = &searchState{match: l.hist, saved: l.buf}
+	for _, key := range "it" {
+		done, _ := l.searchKey(key)
+		if done {
+			t.Fatalf("search ended by %q", key)
+		}
+	}
+	if string(l.buf) != ".items | length" || l.pos != 1 {
+		t.Errorf("found %q at %d", string(l.buf), l.pos)
+	}
+	l.searchKey(ctrlR) // an older match
+	if string(l.buf) != ".items[]" || l.hist != 0 {
+		t.Errorf("found older %q (line %d)", string(l.buf), l.hist)
+	}
+	l.searchKey(ctrlR) // no older match
+	if string(l.buf) != ".items[]" {
+		t.Errorf("found %q with no older match", string(l.buf))
+	}
+	done, accept := l.searchKey(keyEnter)
+	if !done || !accept || l.search != nil {
+		t.Errorf("enter: done %v accept %v", done, accept)
+	}
+
+	// canceling the search restores the line.
+	l = testLineState("draft", 5, ".items[]", ".name")
+	l.search = &searchState{match: l.hist, saved: l.buf}
+	l.searchKey('n')
+	if string(l.buf) != ".name" {
+		t.Errorf("found %q", string(l.buf))
+	}
+	done, accept = l.searchKey(ctrlG)
+	if !done || accept || string(l.buf) != "draft" || l.pos != 5 {
+		t.Errorf("cancel: done %v accept %v line %q at %d", done, accept, string(l.buf), l.pos)
+	}
+
+	// other keys end the search and are handled by the editor.
+	l = testLineState("", 0, ".items[]")
+	l.search = &searchState{match: l.hist, saved: l.buf}
+	l.searchKey('.')
+	done, accept = l.searchKey(ctrlE)
+	if !done || accept || string(l.buf) != ".items[]" {
+		t.Errorf("Ctrl-E: done %v accept %v line %q", done, accept, string(l.buf))
+	}
+}
@@ -0,0 +1,443 @@
+// options.go
+// settings that change how jq is executed and how the shell behaves
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// JQOptions holds the settings changed with :set and the variables bound with
+// :let.  Most correspond to jq command line flags.  The rest change how the
+// shell presents output.
+type JQOptions struct {
+	RawOutput bool    // -r
+	Compact   bool    // -c
+	SortKeys  bool    // -S
+	Slurp     bool    // -s
+	NullInput bool    // -n
+	Tab       bool    // --tab
+	ASCII     bool    // -a
+	Indent    int     // --indent n, or jq's default when zero
+	Stream    bool    // --stream, set by :load -stream instead of :set
+	LibPath   string  // -L for each directory in the list
+	Vars      []JQVar // variables bound with :let, :letjson and :letfile
+	Defs      []JQDef // definitions made with :def
+
+	Color     bool          // colorize paged output
+	Pager     string        // the command output is paged through
+	Prompt    string        // the interactive shell prompt
+	Validate  string        // how a filter is checked before the stack changes
+	Samples   int           // the number of input values checked by "sample" validation
+	Timeout   time.Duration // the time allowed for checking a filter
+	Cache     string        // where the output of each stack level is cached
+	CacheSize int           // the megabytes of output cached in memory
+}
+
+// Validation strategies for the Validate option.  Filters are checked by
+// running them over empty input, over a sample of the input values, or not at
+// all.
+const (
+	ValidateEmpty  = "empty"
+	ValidateSample = "sample"
+	ValidateNone   = "none"
+)
+
+// DefaultJQOptions returns the settings of a new shell.
+func DefaultJQOptions() JQOptions {
+	return JQOptions{
+		Color:     true,
+		Pager:     "less -X -r",
+		Prompt:    "> ",
+		Validate:  ValidateEmpty,
+		Samples:   10,
+		Timeout:   10 * time.Second,
+		Cache:     CacheOff,
+		CacheSize: 256,
+	}
+}
+
+// Args returns the jq command line flags corresponding to o.  Args returns nil
+// if o is nil.
+func (o *JQOptions) Args() []string {
+	if o == nil {
+		return nil
+	}
+	var args []string
+	for _, f := range []struct {
+		on   bool
+		flag string
+	}{
+		{o.RawOutput, "-r"},
+		{o.Compact, "-c"},
+		{o.SortKeys, "-S"},
+		{o.Stream, "--stream"},
+		{o.Slurp, "-s"},
+		{o.NullInput, "-n"},
+		{o.Tab, "--tab"},
+		{o.ASCII, "-a"},
+	} {
+		if f.on {
+			args = append(args, f.flag)
+		}
+	}
+	if o.Indent > 0 {
+		args = append(args, "--indent", strconv.Itoa(o.Indent))
+	}
+	for _, dir := range filepath.SplitList(o.LibPath) {
+		args = append(args, "-L", dir)
+	}
+	for _, v := range o.Vars {
+		args = append(args, v.Args()...)
+	}
+	return args
+}
+
+// filterOptions returns the options in o which change the values a filter is
+// applied to, ignoring those which only format output.
+func (o *JQOptions) filterOptions() *JQOptions {
+	if o == nil {
+		return nil
+	}
+	return &JQOptions{
+		Stream:    o.Stream,
+		Slurp:     o.Slurp,
+		NullInput: o.NullInput,
+		LibPath:   o.LibPath,
+		Vars:      o.Vars,
+		Defs:      o.Defs,
+	}
+}
+
+// pager returns the command output is paged through, or nil to use Page's
+// default.
+func (o *JQOptions) pager() []string {
+	if o == nil {
+		return nil
+	}
+	cmd, err := tokenizeCommand(o.Pager, 0)
+	if err != nil {
+		return nil
+	}
+	return cmd
+}
+
+// flagSet returns a flag.FlagSet whose flags are bound to the fields of o.
+// Setting a flag changes o.
+func (o *JQOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var((*boolValue)(&o.RawOutput), "raw", "output strings without quotes (jq -r)")
+	fs.Var((*boolValue)(&o.Compact), "compact", "output each value on one line (jq -c)")
+	fs.Var((*boolValue)(&o.SortKeys), "sort", "sort object keys in output (jq -S)")
+	fs.Var((*boolValue)(&o.Slurp), "slurp", "apply the filter to an array of all input values (jq -s)")
+	fs.Var((*boolValue)(&o.NullInput), "null-input", "apply the filter once to null instead of the input (jq -n)")
+	fs.Var((*boolValue)(&o.Tab), "tab", "indent output with tabs (jq --tab)")
+	fs.Var((*boolValue)(&o.ASCII), "ascii", "escape non-ASCII characters in output (jq -a)")
+	fs.Var((*indentValue)(&o.Indent), "indent", "indent output with n spaces, 1 through 7, 0 for jq's default (jq --indent)")
+	fs.Var((*stringValue)(&o.LibPath), "libpath", "a list of directories searched for jq modules (jq -L)")
+	fs.Var((*boolValue)(&o.Color), "color", "colorize output written to the pager")
+	fs.Var((*commandValue)(&o.Pager), "pager", "the command used to page output")
+	fs.Var((*stringValue)(&o.Prompt), "prompt", "the interactive shell prompt")
+	fs.Var((*validateValue)(&o.Validate), "validate", "how filters are checked before changing the stack (empty, sample or none)")
+	fs.Var((*countValue)(&o.Samples), "samples", "the number of input values checked when validate is sample")
+	fs.Var((*durationValue)(&o.Timeout), "timeout", "the time allowed for checking a filter (e.g. 30s)")
+	fs.Var((*cacheValue)(&o.Cache), "cache", "cache the output of each stack level (off, memory or file)")
+	fs.Var((*countValue)(&o.CacheSize), "cachesize", "the megabytes of output cached in memory")
+	return fs
+}
+
+// Set changes the named option to value.
+func (o *JQOptions) Set(name, value string) error {
+	fs := o.flagSet()
+	if fs.Lookup(name) == nil {
+		return fmt.Errorf("unknown option %q", name)
+	}
+	err := fs.Set(name, value)
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q: %v", name, value, err)
+	}
+	return nil
+}
+
+// Unset restores the named option to its default value.
+func (o *JQOptions) Unset(name string) error {
+	def := DefaultJQOptions()
+	f := def.flagSet().Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown option %q", name)
+	}
+	return o.Set(name, f.Value.String())
+}
+
+// Changed returns the options in o which differ from their default values.
+func (o *JQOptions) Changed() map[string]string {
+	def := DefaultJQOptions()
+	deffs := def.flagSet()
+	changed := make(map[string]string)
+	o.flagSet().VisitAll(func(f *flag.Flag) {
+		val := f.Value.String()
+		if val != deffs.Lookup(f.Name).Value.String() {
+			changed[f.Name] = val
+		}
+	})
+	return changed
+}
+
+// isCommandOption returns true if the named option holds a command run by the
+// shell, like the pager.
+func isCommandOption(name string) bool {
+	f := new(JQOptions).flagSet().Lookup(name)
+	if f == nil {
+		return false
+	}
+	_, ok := f.Value.(*commandValue)
+	return ok
+}
+
+func optionNames() []string {
+	var names []string
+	new(JQOptions).flagSet().VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+type boolValue bool
+
+func (b *boolValue) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b = boolValue(v)
+	return nil
+}
+
+func (b *boolValue) String() string   { return strconv.FormatBool(bool(*b)) }
+func (b *boolValue) IsBoolFlag() bool { return true }
+
+type indentValue int
+
+func (n *indentValue) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if v < 0 || v > 7 {
+		return fmt.Errorf("out of range")
+	}
+	*n = indentValue(v)
+	return nil
+}
+
+func (n *indentValue) String() string { return strconv.Itoa(int(*n)) }
+
+// countValue is a positive integer.
+type countValue int
+
+func (n *countValue) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("not positive")
+	}
+	*n = countValue(v)
+	return nil
+}
+
+func (n *countValue) String() string { return strconv.Itoa(int(*n)) }
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("not positive")
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+
+type validateValue string
+
+func (v *validateValue) Set(s string) error {
+	switch s {
+	case ValidateEmpty, ValidateSample, ValidateNone:
+		*v = validateValue(s)
+		return nil
+	}
+	return fmt.Errorf("unknown strategy")
+}
+
+func (v *validateValue) String() string { return string(*v) }
+
+type cacheValue string
+
+func (v *cacheValue) Set(s string) error {
+	switch s {
+	case CacheOff, CacheMemory, CacheFile:
+		*v = cacheValue(s)
+		return nil
+	}
+	return fmt.Errorf("unknown cache")
+}
+
+func (v *cacheValue) String() string { return string(*v) }
+
+type stringValue string
+
+func (s *stringValue) Set(v string) error {
+	*s = stringValue(v)
+	return nil
+}
+
+func (s *stringValue) String() string { return string(*s) }
+
+// commandValue is a command line tokenized like the arguments of a shell
+// command.
+type commandValue string
+
+func (c *commandValue) Set(v string) error {
+	cmd, err := tokenizeCommand(v, 0)
+	if err != nil {
+		return err
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	*c = commandValue(v)
+	return nil
+}
+
+func (c *commandValue) String() string { return string(*c) }
+
+// applyOptions makes changes to jq.Options which affect the shell reader.
+func (jq *JQShell) applyOptions() {
+	if sh, ok := jq.sh.(Prompting); ok && !jq.script {
+		sh.SetPrompt(jq.Options.Prompt)
+	}
+}
+
+func cmdSet(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command set changes an option.")
+	flags.ArgSet("option", "[value]")
+	flags.ArgDoc("option", "the name of an option listed by :show")
+	flags.ArgDoc("value", "the new value (boolean options are set to true if omitted)")
+	flags.Docs(
+		"Options changing the output of jq are passed to jq as flags by :write,",
+		"\":pipe -out\" and other commands, and are included in the output of",
+		":script.",
+	)
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after set")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	args := flags.Args()
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("expects an option and a value")
+	}
+	name := args[0]
+	var value string
+	if len(args) == 2 {
+		value = args[1]
+	} else {
+		value = "true"
+		f := jq.Options.flagSet().Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if _, ok := f.Value.(*boolValue); !ok {
+			return fmt.Errorf("option %s requires a value", name)
+		}
+	}
+	err = jq.Options.Set(name, value)
+	if err != nil {
+		return err
+	}
+	jq.applyOptions()
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdUnset(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command unset restores options to their default values.")
+	flags.ArgSet("option", "...")
+	flags.ArgDoc("option", "the name of an option listed by :show")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after unset")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects at least one option")
+	}
+	for _, name := range flags.Args() {
+		err := jq.Options.Unset(name)
+		if err != nil {
+			return err
+		}
+	}
+	jq.applyOptions()
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdShow(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command show prints the value of options.")
+	flags.ArgSet("[option]", "...")
+	flags.ArgDoc("option", "the name of an option (all options if omitted)")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fs := jq.Options.flagSet()
+	names := flags.Args()
+	if len(names) == 0 {
+		names = optionNames()
+	}
+	changed := jq.Options.Changed()
+	tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		cur := " "
+		if _, ok := changed[name]; ok {
+			cur = "*"
+		}
+		fmt.Fprintf(tw, "%s %s\t%q\t%s\n", cur, f.Name, f.Value.String(), f.Usage)
+	}
+	return tw.Flush()
+}
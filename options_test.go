@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJQOptionsArgs(t *testing.T) {
+	var nilopts *JQOptions
+	if args := nilopts.Args(); args != nil {
+		t.Errorf("nil options: got %q", args)
+	}
+	opts := DefaultJQOptions()
+	if args := opts.Args(); args != nil {
+		t.Errorf("default options: got %q", args)
+	}
+	opts.RawOutput = true
+	opts.Slurp = true
+	opts.Indent = 4
+	expect := []string{"-r", "-s", "--indent", "4"}
+	if args := opts.Args(); !reflect.DeepEqual(args, expect) {
+		t.Errorf("got %q (expect %q)", args, expect)
+	}
+}
+
+func TestJQOptionsSet(t *testing.T) {
+	opts := DefaultJQOptions()
+	for i, test := range []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"raw", "true", true},
+		{"compact", "yes", false},
+		{"indent", "3", true},
+		{"indent", "8", false},
+		{"pager", "more", true},
+		{"pager", "'less", false},
+		{"validate", "sample", true},
+		{"validate", "always", false},
+		{"samples", "0", false},
+		{"timeout", "30s", true},
+		{"timeout", "30", false},
+		{"unknown", "1", false},
+	} {
+		err := opts.Set(test.name, test.value)
+		if test.ok && err != nil {
+			t.Errorf("test %d (%s=%q): %v", i, test.name, test.value, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("test %d (%s=%q): expected an error", i, test.name, test.value)
+		}
+	}
+	expect := map[string]string{"raw": "true", "indent": "3", "pager": "more", "validate": "sample", "timeout": "30s"}
+	if changed := opts.Changed(); !reflect.DeepEqual(changed, expect) {
+		t.Errorf("changed %q (expect %q)", changed, expect)
+	}
+	err := opts.Unset("pager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Pager != DefaultJQOptions().Pager {
+		t.Errorf("unset pager %q", opts.Pager)
+	}
+}
+
+func TestJQOptionsVars(t *testing.T) {
+	var opts JQOptions
+	opts.SetVar(JQVar{Name: "a", Kind: VarString, Value: "x"})
+	opts.SetVar(JQVar{Name: "b", Kind: VarJSON, Value: "1"})
+	opts.SetVar(JQVar{Name: "a", Kind: VarFile, Value: "a.json"})
+	expect := []string{"--argjson", "b", "1", "--slurpfile", "a", "a.json"}
+	if args := opts.Args(); !reflect.DeepEqual(args, expect) {
+		t.Errorf("got %q (expect %q)", args, expect)
+	}
+	if !opts.UnsetVar("a") {
+		t.Errorf("variable a not removed")
+	}
+	if opts.UnsetVar("a") {
+		t.Errorf("variable a removed twice")
+	}
+	expect = []string{"--argjson", "b", "1"}
+	if args := opts.Args(); !reflect.DeepEqual(args, expect) {
+		t.Errorf("got %q (expect %q)", args, expect)
+	}
+}
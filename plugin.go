@@ -0,0 +1,257 @@
+// plugin.go
+// external commands found on PATH
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PluginPrefix begins the names of executables which are run as commands.  An
+// executable named "jqsh-count" on PATH can be run with ":count".
+const PluginPrefix = "jqsh-"
+
+// PluginVersion is the version of the JSON document written to plugins.
+const PluginVersion = 1
+
+// A Plugin is a command implemented by an external executable.  The plugin
+// receives its arguments on the command line, the state of the shell as a
+// PluginState on stdin and in JQSH_* environment variables.  If the plugin
+// writes a PluginResponse to stdout its actions are performed, otherwise its
+// output is printed.
+type Plugin struct {
+	Name string
+	Path string
+	help *string
+}
+
+var _ JQShellCommand = (*Plugin)(nil)
+
+// PluginState describes the shell to a plugin.
+type PluginState struct {
+	Version int               `json:"version"`
+	Filter  string            `json:"filter"`          // the joined filter stack
+	Program string            `json:"program"`         // the filter preceded by definitions
+	Stack   []string          `json:"stack"`           // the filters on the stack
+	Input   string            `json:"input,omitempty"` // a file containing the input
+	Source  *InputSource      `json:"source,omitempty"`
+	JQ      string            `json:"jq"`      // the jq executable
+	JQArgs  []string          `json:"jq_args"` // flags passed to jq with the program
+	Options map[string]string `json:"options,omitempty"`
+	Args    []string          `json:"args"`
+}
+
+// PluginResponse is written by a plugin to change the state of the shell.
+type PluginResponse struct {
+	Actions []PluginAction `json:"actions"`
+}
+
+// PluginAction is one change requested by a plugin.  Exactly one field should
+// be set.
+type PluginAction struct {
+	Push    string   `json:"push,omitempty"`    // push a filter
+	Pop     int      `json:"pop,omitempty"`     // pop filters
+	Load    string   `json:"load,omitempty"`    // set the input to a file
+	Print   string   `json:"print,omitempty"`   // print a message
+	Command []string `json:"command,omitempty"` // run a command
+}
+
+// findPlugins returns the paths of plugin executables in the directories of
+// path, a list like the PATH environment variable, keyed by command name.
+// Earlier directories take precedence.
+func findPlugins(path string) map[string]string {
+	plugins := make(map[string]string)
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			name := strings.TrimPrefix(info.Name(), PluginPrefix)
+			if name == info.Name() || name == "" {
+				continue
+			}
+			if info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			if _, ok := plugins[name]; !ok {
+				plugins[name] = filepath.Join(dir, info.Name())
+			}
+		}
+	}
+	return plugins
+}
+
+// registerPlugins registers plugins on PATH whose names are not taken by
+// other commands.
+func (lib *Lib) registerPlugins() {
+	plugins := findPlugins(os.Getenv("PATH"))
+	var names []string
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if lib.taken(name) == nil {
+			lib.Register(name, &Plugin{Name: name, Path: plugins[name]})
+		}
+	}
+}
+
+// Help returns the output of the plugin run with the "--help" flag.  The
+// output is remembered after the first call.
+func (p *Plugin) Help() string {
+	if p.help == nil {
+		bs, err := exec.Command(p.Path, "--help").Output()
+		help := strings.TrimSpace(string(bs))
+		if err != nil || help == "" {
+			help = fmt.Sprintf("Plugin %s has no help.", p.Name)
+		}
+		p.help = &help
+	}
+	return *p.help
+}
+
+func (p *Plugin) ExecuteShellCommand(jq *JQShell, flags *CmdFlags) error {
+	if len(flags.args) == 1 && isHelpFlag(flags.args[0]) {
+		flags.About(p.Help())
+		flags.ArgSet("[arg]", "...")
+		flags.Docs(fmt.Sprintf("Plugin %s is run from %s.", p.Name, p.Path))
+		flags.Parse(nil)
+		return nil
+	}
+
+	state := jq.pluginState(flags.args)
+	statejs, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(p.Path, flags.args...)
+	cmd.Stdin = bytes.NewReader(statejs)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"JQSH_FILTER="+state.Filter,
+		"JQSH_PROGRAM="+state.Program,
+		"JQSH_INPUT="+state.Input,
+		"JQSH_JQ="+state.JQ,
+	)
+	err = cmd.Run()
+	if err != nil {
+		os.Stdout.Write(stdout.Bytes())
+		return err
+	}
+
+	resp, ok := parsePluginResponse(stdout.Bytes())
+	if !ok {
+		_, err := os.Stdout.Write(stdout.Bytes())
+		return err
+	}
+	return jq.pluginActions(resp.Actions)
+}
+
+// parsePluginResponse returns the response in the output of a plugin.  If
+// the output is not a JSON object with an "actions" key it is not a response.
+func parsePluginResponse(out []byte) (*PluginResponse, bool) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(out, &obj)
+	if err != nil {
+		return nil, false
+	}
+	if _, ok := obj["actions"]; !ok {
+		return nil, false
+	}
+	resp := new(PluginResponse)
+	err = json.Unmarshal(out, resp)
+	if err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// jqPath returns the path of the jq executable used by the shell, or found on
+// PATH if the shell's engine does not run jq.
+func (jq *JQShell) jqPath() string {
+	if pe, ok := jq.engine.(*ProcessEngine); ok && pe.Bin != "" {
+		return pe.Bin
+	}
+	path, _ := LocateJQ("")
+	return path
+}
+
+func (jq *JQShell) pluginState(args []string) *PluginState {
+	state := &PluginState{
+		Version: PluginVersion,
+		Filter:  JoinFilter(jq.Stack),
+		Stack:   filterStrings(jq.Stack.Filters()),
+		Source:  jq.source,
+		JQ:      jq.jqPath(),
+		JQArgs:  jq.Options.Args(),
+		Options: jq.Options.Changed(),
+		Args:    args,
+	}
+	state.Program = jq.Options.Program(state.Filter)
+	if jq.filename != "" {
+		state.Input, _ = filepath.Abs(jq.filename)
+	}
+	if state.Args == nil {
+		state.Args = []string{}
+	}
+	return state
+}
+
+// pluginActions performs actions requested by a plugin, stopping at the first
+// one that fails.  The filter output is written once if the stack or the input
+// changed.
+func (jq *JQShell) pluginActions(actions []PluginAction) error {
+	var changed bool
+	for _, action := range actions {
+		var err error
+		switch {
+		case action.Push != "":
+			err = jq.modifyStack(func(s *JQStack) error {
+				s.Push(FilterString(action.Push))
+				return nil
+			})
+			changed = true
+		case action.Pop > 0:
+			err = jq.changeStack(func(s *JQStack) error {
+				_, err := s.Pop(action.Pop)
+				return err
+			})
+			changed = true
+		case action.Load != "":
+			_, err = os.Stat(action.Load)
+			if err == nil {
+				jq.SetInputFile(action.Load, false)
+				jq.source = &InputSource{File: action.Load}
+				changed = true
+			}
+		case action.Print != "":
+			fmt.Println(action.Print)
+		case len(action.Command) > 0:
+			err = jq.lib.exec(nil, jq, action.Command[0], action.Command[1:])
+		default:
+			err = fmt.Errorf("empty action")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if changed {
+		return jq.writeImplicit()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindPlugins(t *testing.T) {
+	dir1, err := ioutil.TempDir("", "jqsh-plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "jqsh-plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	for _, f := range []struct {
+		dir  string
+		name string
+		mode os.FileMode
+	}{
+		{dir1, "jqsh-a", 0755},
+		{dir1, "jqsh-b", 0644},
+		{dir1, "jqsh-", 0755},
+		{dir1, "other", 0755},
+		{dir2, "jqsh-a", 0755},
+		{dir2, "jqsh-c", 0755},
+	} {
+		err := ioutil.WriteFile(filepath.Join(f.dir, f.name), []byte("#!/bin/sh\n"), f.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Mkdir(filepath.Join(dir2, "jqsh-d"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins := findPlugins(dir1 + string(filepath.ListSeparator) + dir2)
+	expect := map[string]string{
+		"a": filepath.Join(dir1, "jqsh-a"),
+		"c": filepath.Join(dir2, "jqsh-c"),
+	}
+	if !reflect.DeepEqual(plugins, expect) {
+		t.Errorf("got %q (expect %q)", plugins, expect)
+	}
+}
+
+func TestParsePluginResponse(t *testing.T) {
+	for i, test := range []struct {
+		out  string
+		resp *PluginResponse
+	}{
+		{"hello\n", nil},
+		{`{"a": 1}`, nil},
+		{`[{"actions": []}]`, nil},
+		{`{"actions": []}`, &PluginResponse{Actions: []PluginAction{}}},
+		{`{"actions": [{"push": ".a"}, {"command": ["write", "x.json"]}]}`, &PluginResponse{
+			Actions: []PluginAction{{Push: ".a"}, {Command: []string{"write", "x.json"}}},
+		}},
+	} {
+		resp, ok := parsePluginResponse([]byte(test.out))
+		if ok != (test.resp != nil) {
+			t.Errorf("test %d (%q): response %v", i, test.out, ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(resp, test.resp) {
+			t.Errorf("test %d (%q): got %#v (expect %#v)", i, test.out, resp, test.resp)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+// proc_other.go
+// process stubs for systems without process groups
+
+package main
+
+import "os/exec"
+
+func detachProcess(cmd *exec.Cmd) {
+}
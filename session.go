@@ -0,0 +1,309 @@
+// session.go
+// saving and restoring the state of a shell
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// SessionVersion is the version of the session file format written by jqsh.
+// Files with a greater version cannot be restored.
+const SessionVersion = 1
+
+// Session is the state of a shell as stored in a session file.
+type Session struct {
+	Version int                 `json:"version"`
+	Input   *InputSource        `json:"input,omitempty"`
+	Inputs  []*InputSource      `json:"inputs,omitempty"` // inputs loaded with :load -as
+	Stack   []string            `json:"stack"`
+	Marks   map[string][]string `json:"marks,omitempty"`
+	Options map[string]string   `json:"options,omitempty"` // options changed with :set
+	Vars    []JQVar             `json:"vars,omitempty"`
+	Defs    []JQDef             `json:"defs,omitempty"`
+}
+
+// InputSource describes how the shell's input was declared so that it can be
+// declared again when a session is restored.
+type InputSource struct {
+	Name    string `json:"name,omitempty"`    // the name given to :load -as
+	File    string `json:"file,omitempty"`    // a file given to :load
+	Script  string `json:"script,omitempty"`  // a shell script given to :pipe
+	Output  string `json:"output,omitempty"`  // a file produced by Script
+	Delete  bool   `json:"delete,omitempty"`  // delete Output when input changes
+	Ignore  bool   `json:"ignore,omitempty"`  // ignore the exit status of Script
+	NoCache bool   `json:"nocache,omitempty"` // run Script each time input is read
+	Stream  bool   `json:"stream,omitempty"`  // File is streamed (:load -stream)
+}
+
+func filterStrings(filters []Filter) []string {
+	strs := make([]string, len(filters))
+	for i, f := range filters {
+		strs[i] = JoinFilter(f)
+	}
+	return strs
+}
+
+func stringFilters(strs []string) []Filter {
+	filters := make([]Filter, len(strs))
+	for i, s := range strs {
+		filters[i] = FilterString(s)
+	}
+	return filters
+}
+
+// Session returns the current state of jq.
+func (jq *JQShell) Session() *Session {
+	sess := &Session{
+		Version: SessionVersion,
+		Input:   jq.source,
+		Inputs:  jq.inputSources(),
+		Stack:   filterStrings(jq.Stack.Filters()),
+		Vars:    jq.Options.Vars,
+		Defs:    jq.Options.Defs,
+	}
+	if sess.Input != nil {
+		sess.Input = absSource(sess.Input)
+	}
+	for i, source := range sess.Inputs {
+		sess.Inputs[i] = absSource(source)
+	}
+	changed := jq.Options.Changed()
+	if jq.Options.Stream {
+		// set by :load -stream rather than :set.
+		changed["stream"] = "true"
+	}
+	if len(changed) > 0 {
+		sess.Options = changed
+	}
+	if len(jq.marks) > 0 {
+		sess.Marks = make(map[string][]string, len(jq.marks))
+		for name, filters := range jq.marks {
+			sess.Marks[name] = filterStrings(filters)
+		}
+	}
+	return sess
+}
+
+// absSource returns a copy of source with absolute file paths so that a
+// session can be restored from another directory.
+func absSource(source *InputSource) *InputSource {
+	abs := *source
+	for _, path := range []*string{&abs.File, &abs.Output} {
+		if *path != "" {
+			p, err := filepath.Abs(*path)
+			if err == nil {
+				*path = p
+			}
+		}
+	}
+	return &abs
+}
+
+// Restore sets the state of jq to that of sess.  Options not in sess are set
+// to their defaults and variables and definitions not in sess are removed.
+// The input declared in sess is read again.  The filter stack is replaced by
+// the one in sess, which can be undone.
+//
+// Input produced by a shell command and options holding a command, like the
+// pager, are only restored if exec is true.  The session is checked before jq
+// is changed, so an error leaves jq as it was unless the command producing the
+// input fails.
+func (jq *JQShell) Restore(sess *Session, exec bool) error {
+	if sess.Version > SessionVersion {
+		return fmt.Errorf("unsupported session version %d (newer than %d)", sess.Version, SessionVersion)
+	}
+	opts := DefaultJQOptions()
+	var skipped []string
+	for name, value := range sess.Options {
+		if !exec && isCommandOption(name) {
+			skipped = append(skipped, name)
+			continue
+		}
+		if name == "stream" {
+			stream, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("restoring options: invalid stream value %q", value)
+			}
+			opts.Stream = stream
+			continue
+		}
+		err := opts.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("restoring options: %v", err)
+		}
+	}
+	opts.Vars = sess.Vars
+	opts.Defs = sess.Defs
+	for _, source := range sess.Inputs {
+		_, err := os.Stat(source.File)
+		if err != nil {
+			return fmt.Errorf("restoring input $%s: %v", source.Name, err)
+		}
+	}
+	if sess.Input != nil {
+		err := checkInput(sess.Input, exec)
+		if err != nil {
+			return fmt.Errorf("restoring input: %v", err)
+		}
+	}
+
+	sort.Strings(skipped)
+	for _, name := range skipped {
+		jq.logf("option %s not restored because it is a command (use -exec to restore it): %q", name, sess.Options[name])
+	}
+
+	jq.Options = opts
+	jq.applyOptions()
+	jq.removeInputs()
+	for _, source := range sess.Inputs {
+		jq.registerInput(source.Name, source.File, false, source.Stream)
+	}
+	if sess.Input != nil {
+		err := jq.restoreInput(sess.Input)
+		if err != nil {
+			return fmt.Errorf("restoring input: %v", err)
+		}
+	}
+	err := jq.changeStack(func(s *JQStack) error {
+		s.SetFilters(stringFilters(sess.Stack))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	jq.marks = make(map[string][]Filter, len(sess.Marks))
+	for name, strs := range sess.Marks {
+		jq.marks[name] = stringFilters(strs)
+	}
+	return nil
+}
+
+// checkInput returns an error if the input described by source cannot be
+// restored.  Input produced by a command can only be restored if exec is
+// true.
+func checkInput(source *InputSource, exec bool) error {
+	switch {
+	case source.Name != "" || source.File != "":
+		_, err := os.Stat(source.File)
+		return err
+	case source.Script != "":
+		if !exec {
+			return fmt.Errorf("input is the output of the command %q (use -exec to run it)", source.Script)
+		}
+		return nil
+	}
+	return fmt.Errorf("no file or script")
+}
+
+func (jq *JQShell) restoreInput(source *InputSource) error {
+	switch {
+	case source.Name != "":
+		if _, ok := jq.inputs[source.Name]; !ok {
+			jq.registerInput(source.Name, source.File, false, source.Stream)
+		}
+		return jq.useInput(source.Name)
+	case source.File != "":
+		_, err := os.Stat(source.File)
+		if err != nil {
+			return err
+		}
+		jq.SetInputFile(source.File, false)
+		jq.source = source
+		jq.Options.Stream = source.Stream
+		return nil
+	case source.Script != "":
+		return pipeFrom(jq, source.Script, &InputPipeOptions{
+			Quiet:     true,
+			KeepStack: true,
+			Ignore:    source.Ignore,
+			Filename:  source.Output,
+			Delete:    source.Delete,
+			NoCache:   source.NoCache,
+		})
+	}
+	return fmt.Errorf("no file or script")
+}
+
+func cmdSave(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command save writes the session state to a file.")
+	flags.ArgSet("filename")
+	flags.ArgDoc("filename", "the session file to write")
+	flags.Docs(
+		"The session file contains the filter stack, marks, options changed",
+		"with :set, variables, function definitions, and the input declared",
+		"with :load or :pipe.  It can be restored with :restore or the",
+		"-session command line flag.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expects one filename")
+	}
+	bs, err := json.MarshalIndent(jq.Session(), "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(bs, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func cmdRestore(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command restore sets the session state from a file written by :save.")
+	flags.ArgSet("filename")
+	flags.ArgDoc("filename", "a session file")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after restoring")
+	exec := flags.Bool("exec", false, "run the shell command producing the session's input")
+	flags.Docs(
+		"A session whose input was declared with :pipe is only restored with",
+		"-exec, because restoring it runs the command saved in the file.  The",
+		"pager option is also only restored with -exec.",
+		"Nothing is changed if the session cannot be restored.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expects one filename")
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sess := new(Session)
+	err = json.NewDecoder(f).Decode(sess)
+	if err != nil {
+		return fmt.Errorf("invalid session file: %v", err)
+	}
+	err = jq.Restore(sess, *exec)
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	jq := &JQShell{
+		Stack:   new(JQStack),
+		History: NewStackHistory(10),
+		Options: DefaultJQOptions(),
+		marks: map[string][]Filter{
+			"a": {FilterString(".a")},
+		},
+	}
+	jq.Options.RawOutput = true
+	jq.Options.Indent = 4
+	jq.Options.Stream = true // kept with no input source
+	jq.Options.SetVar(JQVar{Name: "x", Kind: VarJSON, Value: "[1]"})
+	jq.Stack.Push(FilterString(".items[]"))
+	jq.Stack.Push(FilterString(".name"))
+
+	bs, err := json.Marshal(jq.Session())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	sess := new(Session)
+	err = json.Unmarshal(bs, sess)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if sess.Version != SessionVersion {
+		t.Errorf("version %d (expect %d)", sess.Version, SessionVersion)
+	}
+
+	restored := &JQShell{Stack: new(JQStack), History: NewStackHistory(10)}
+	err = restored.Restore(sess, false)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if JoinFilter(restored.Stack) != JoinFilter(jq.Stack) {
+		t.Errorf("stack %q (expect %q)", JoinFilter(restored.Stack), JoinFilter(jq.Stack))
+	}
+	if !reflect.DeepEqual(restored.Options, jq.Options) {
+		t.Errorf("options %#v (expect %#v)", restored.Options, jq.Options)
+	}
+	if !reflect.DeepEqual(restored.Session(), jq.Session()) {
+		t.Errorf("session %#v (expect %#v)", restored.Session(), jq.Session())
+	}
+}
+
+func TestSessionVersion(t *testing.T) {
+	jq := &JQShell{Stack: new(JQStack), History: NewStackHistory(10)}
+	err := jq.Restore(&Session{Version: SessionVersion + 1}, false)
+	if err == nil {
+		t.Errorf("restored a session with an unsupported version")
+	}
+}
+
+func TestSessionRestoreFails(t *testing.T) {
+	for i, sess := range []*Session{
+		{Options: map[string]string{"indent": "x"}, Stack: []string{".b"}},
+		{Input: &InputSource{File: "missing.json"}, Stack: []string{".b"}},
+		{Inputs: []*InputSource{{Name: "m", File: "missing.json"}}, Stack: []string{".b"}},
+		{Input: &InputSource{Script: "echo 1"}, Stack: []string{".b"}},
+		{Input: &InputSource{}, Stack: []string{".b"}},
+	} {
+		jq := &JQShell{
+			Stack:   new(JQStack),
+			History: NewStackHistory(10),
+			Options: DefaultJQOptions(),
+		}
+		jq.Options.Indent = 4
+		jq.SetInputFile("example.json", false)
+		jq.Stack.Push(FilterString(".a"))
+		before := jq.Session()
+		err := jq.Restore(sess, false)
+		if err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+		if !reflect.DeepEqual(jq.Session(), before) {
+			t.Errorf("test %d: session changed to %#v", i, jq.Session())
+		}
+		if jq.filename != "example.json" {
+			t.Errorf("test %d: input changed to %q", i, jq.filename)
+		}
+	}
+}
+
+func TestSessionRestorePager(t *testing.T) {
+	sess := &Session{
+		Options: map[string]string{"pager": "touch pwned", "indent": "3"},
+		Stack:   []string{".a"},
+	}
+	for _, exec := range []bool{false, true} {
+		var logbuf bytes.Buffer
+		jq := &JQShell{
+			Log:     log.New(&logbuf, "", 0),
+			Stack:   new(JQStack),
+			History: NewStackHistory(10),
+			Options: DefaultJQOptions(),
+		}
+		err := jq.Restore(sess, exec)
+		if err != nil {
+			t.Fatalf("exec %v: %v", exec, err)
+		}
+		if jq.Options.Indent != 3 {
+			t.Errorf("exec %v: indent %d (expect 3)", exec, jq.Options.Indent)
+		}
+		if exec {
+			if jq.Options.Pager != "touch pwned" {
+				t.Errorf("exec %v: pager %q not restored", exec, jq.Options.Pager)
+			}
+			continue
+		}
+		if jq.Options.Pager != DefaultJQOptions().Pager {
+			t.Errorf("exec %v: pager %q restored", exec, jq.Options.Pager)
+		}
+		if !strings.Contains(logbuf.String(), "pager") {
+			t.Errorf("exec %v: no warning logged (%q)", exec, logbuf.String())
+		}
+	}
+}
+
+func TestSessionAbsolutePaths(t *testing.T) {
+	jq := &JQShell{Stack: new(JQStack), History: NewStackHistory(10), Options: DefaultJQOptions()}
+	jq.source = &InputSource{File: "example.json"}
+	jq.registerInput("ex", "example.json", false, false)
+	sess := jq.Session()
+	abs, err := filepath.Abs("example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Input.File != abs {
+		t.Errorf("input %q (expect %q)", sess.Input.File, abs)
+	}
+	if len(sess.Inputs) != 1 || sess.Inputs[0].File != abs {
+		t.Errorf("inputs %#v (expect %q)", sess.Inputs, abs)
+	}
+	if jq.source.File != "example.json" {
+		t.Errorf("shell input changed to %q", jq.source.File)
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Page returns an io.Writer whose input will be written to the pager program.
@@ -82,32 +84,121 @@ Lines prefixed with a colon ':' are commands, other lines are shorthand for
 specific commands.  Following is a list of all shell syntax in jqsh.
 
 	:<cmd> <arg1> <arg2> ...    execute cmd with the given arguments
-	:<cmd> ... +<argN>          execute cmd with an argument containing spaces (argN)
+	:<cmd> ... +<argN>          execute cmd with the rest of the line as an argument (argN)
+	:<cmd> ... <<<term>         execute cmd with following lines up to <term> as an argument
 	.                           shorthand for ":write"
 	..                          shorthand for ":pop"
 	?<filter>                   shorthand for ":peek +<filter>"
+	def <name>: <body>;         shorthand for ":def +def <name>: <body>;"
 	<filter>                    shorthand for ":push +<filter>"
 
 Note that "." is a valid jq filter but pushing it on the filter stack lacks
 semantic value.  So "." alone on a line is used as a shorthand for ":write".
+Lines beginning with "#" are comments and are ignored.
+
+Command arguments are separated by spaces.  Arguments containing spaces or
+other special characters can be quoted like in a unix shell.  Text inside
+single quotes is taken literally.  Inside double quotes, or outside of quotes,
+a backslash escapes the character following it.  An argument beginning with
+"+" is the rest of the line, taken literally.  The filter given to :push or
+:peek is also the rest of the line, quotes included, when its words are not
+separate filters, as in ":peek .a + .b".
+
+	> :load "my file.json"
+	> :peek '.a + .b'
+	> :peek .name == "hal"
+	> :pipe -out +grep -v 'hello world'
+
+A filter containing unclosed brackets, parentheses, braces or strings, or
+ending with a pipe "|", continues on the next line.  The complete filter is
+pushed as a single item on the stack.
+
+	> .items[] | {
+	... name: .name,
+	... kind: .type
+	... }
+
+Function definitions continue on following lines until they end with a
+semicolon.
+
+	> def total(f):
+	...   reduce f as $x (0; . + $x);
+
+Longer programs can be given as a block which is terminated by a line
+containing only <term> (or "EOF" if no terminator is given).
+
+	> :push <<END
+	... def count(f): reduce f as $x (0; . + 1);
+	... count(.items[])
+	... END
 `
 
 type SimpleShellReader struct {
-	r      io.Reader
-	br     *bufio.Reader
-	out    io.Writer
-	prompt string
+	r          io.Reader
+	br         *bufio.Reader
+	ed         *LineEditor
+	out        io.Writer
+	prompt     string
+	contprompt string
+	name       string // the name of the input, for error positions
+	line       int    // the number of lines read
+	cmdline    int    // the line on which the last command started
+	mut        sync.Mutex
+	reading    string // the prompt of the line being read
 }
 
 var _ ShellReader = (*SimpleShellReader)(nil)
 var _ Documented = (*SimpleShellReader)(nil)
+var _ Completing = (*SimpleShellReader)(nil)
+var _ LineEditing = (*SimpleShellReader)(nil)
+var _ Interruptible = (*SimpleShellReader)(nil)
 
+// NewShellReader returns a SimpleShellReader that reads commands from r.  If r
+// is nil commands are read from stdin.  When stdin and stdout are both
+// terminals lines are read with a LineEditor so they may be edited and
+// recalled from history.
 func NewShellReader(r io.Reader, prompt string) *SimpleShellReader {
+	var ed *LineEditor
 	if r == nil {
 		r = os.Stdin
+		if isTerminal(os.Stdin.Fd()) && isTerminal(os.Stdout.Fd()) {
+			ed = NewLineEditor(os.Stdin, os.Stdout)
+		}
 	}
 	br := bufio.NewReader(r)
-	return &SimpleShellReader{r, br, os.Stdout, prompt}
+	contprompt := ""
+	if prompt != "" {
+		contprompt = "... "
+	}
+	return &SimpleShellReader{
+		r:          r,
+		br:         br,
+		ed:         ed,
+		out:        os.Stdout,
+		prompt:     prompt,
+		contprompt: contprompt,
+	}
+}
+
+// SetPrompt changes the prompt printed before each command is read.
+func (s *SimpleShellReader) SetPrompt(prompt string) {
+	s.prompt = prompt
+}
+
+// SetName sets the name of the input, typically a file name.  Once a name is
+// set Position reports where each command was read.
+func (s *SimpleShellReader) SetName(name string) {
+	s.name = name
+}
+
+// Position returns the name of the input and the line on which the last
+// command read started, separated by a colon.  Position returns an empty
+// string if the input has no name.
+func (s *SimpleShellReader) Position() string {
+	if s.name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", s.name, s.cmdline)
 }
 
 func (s *SimpleShellReader) Documentation() string {
@@ -118,6 +209,16 @@ func (s *SimpleShellReader) SetOutput(w io.Writer) {
 	s.out = w
 }
 
+// SetHistoryFile loads the line history stored at path and appends lines
+// entered in the future to it.  SetHistoryFile does nothing when s is not
+// reading from a terminal.
+func (s *SimpleShellReader) SetHistoryFile(path string) error {
+	if s.ed == nil {
+		return nil
+	}
+	return s.ed.History.Load(path)
+}
+
 func (s *SimpleShellReader) print(v ...interface{}) {
 	if s.out != nil {
 		fmt.Fprint(s.out, v...)
@@ -130,11 +231,124 @@ func (s *SimpleShellReader) println(v ...interface{}) {
 	}
 }
 
+// SetCompleter sets the function used to complete input when the user presses
+// Tab.  SetCompleter does nothing when s is not reading from a terminal.
+func (s *SimpleShellReader) SetCompleter(c Completer) {
+	if s.ed != nil {
+		s.ed.Complete = c
+	}
+}
+
+// EditLine lets the user edit text interactively.  EditLine returns an error
+// if s is not reading from a terminal.
+func (s *SimpleShellReader) EditLine(prompt, text string) (string, error) {
+	if s.ed == nil {
+		return "", fmt.Errorf("interactive editing requires a terminal")
+	}
+	return s.ed.EditLine(prompt, text)
+}
+
+// readLine prompts for and reads a line of input.
+func (s *SimpleShellReader) readLine(prompt string) ([]byte, error) {
+	s.line++
+	if s.ed != nil {
+		line, err := s.ed.ReadLine(prompt)
+		return []byte(line), err
+	}
+	s.mut.Lock()
+	s.reading = prompt
+	s.mut.Unlock()
+	s.print(prompt)
+	return s.br.ReadBytes('\n')
+}
+
+// Interrupt prints the prompt again on a new line after Ctrl-C is pressed
+// while a line is read without a line editor.  The terminal has already
+// discarded the text typed on the line.  A line editor reads Ctrl-C as a key
+// and clears the line itself.
+func (s *SimpleShellReader) Interrupt() {
+	if s.ed != nil {
+		return
+	}
+	s.mut.Lock()
+	prompt := s.reading
+	s.mut.Unlock()
+	if prompt != "" {
+		s.print("\n" + prompt)
+	}
+}
+
+// readFilter reads continuation lines until filter is complete, joining them
+// with newlines.  The filter is returned as is when input ends.
+func (s *SimpleShellReader) readFilter(filter string, eof bool) (string, bool, error) {
+	for !eof && filterIncomplete(filter) {
+		bs, err := s.readLine(s.contprompt)
+		eof = err == io.EOF
+		if eof && s.contprompt != "" {
+			s.println()
+		}
+		if err != nil && !eof {
+			return "", eof, err
+		}
+		filter += "\n" + string(bytes.TrimRightFunc(bs, unicode.IsSpace))
+	}
+	return filter, eof, nil
+}
+
+// readDef reads continuation lines until the function definitions in def are
+// terminated by a semicolon.  If a filter follows the definitions the filter
+// is read instead and the returned bool is false.
+func (s *SimpleShellReader) readDef(def string, eof bool) (string, bool, bool, error) {
+	for {
+		end := defsEnd(def)
+		if end >= 0 && !onlyComments(def[end:]) {
+			filter, eof, err := s.readFilter(def, eof)
+			return filter, false, eof, err
+		}
+		if eof || end >= 0 {
+			return def, true, eof, nil
+		}
+		bs, err := s.readLine(s.contprompt)
+		eof = err == io.EOF
+		if eof && s.contprompt != "" {
+			s.println()
+		}
+		if err != nil && !eof {
+			return "", false, eof, err
+		}
+		def += "\n" + string(bytes.TrimRightFunc(bs, unicode.IsSpace))
+	}
+}
+
+// readBlock reads lines until one consists of term, returning the lines
+// before it joined with newlines.
+func (s *SimpleShellReader) readBlock(term string) (string, bool, error) {
+	var lines []string
+	for {
+		bs, err := s.readLine(s.contprompt)
+		eof := err == io.EOF
+		if eof && s.contprompt != "" {
+			s.println()
+		}
+		if err != nil && !eof {
+			return "", eof, err
+		}
+		line := string(bytes.TrimRightFunc(bs, unicode.IsSpace))
+		if strings.TrimSpace(line) == term {
+			return strings.Join(lines, "\n"), eof, nil
+		}
+		if eof {
+			msg := fmt.Sprintf("block is missing terminator %q", term)
+			return "", eof, InvalidCommandError{Message: msg}
+		}
+		lines = append(lines, line)
+	}
+}
+
 func (s *SimpleShellReader) ReadCommand() (cmd []string, eof bool, err error) {
-	s.print(s.prompt)
-	bs, err := s.br.ReadBytes('\n')
+	bs, err := s.readLine(s.prompt)
 	eof = err == io.EOF
-	if eof {
+	if eof && s.prompt != "" {
 		s.println()
 	}
 	if err != nil {
@@ -145,8 +359,9 @@ func (s *SimpleShellReader) ReadCommand() (cmd []string, eof bool, err error) {
 		}
 	}
 	bs = bytes.TrimFunc(bs, unicode.IsSpace)
+	s.cmdline = s.line
 
-	if len(bs) == 0 {
+	if len(bs) == 0 || bs[0] == '#' {
 		if eof {
 			return nil, eof, nil
 		}
@@ -158,54 +373,428 @@ func (s *SimpleShellReader) ReadCommand() (cmd []string, eof bool, err error) {
 		cmd := []string{"write"}
 		return cmd, eof, nil
 	} else if bs[0] == '?' {
-		str := string(bs[1:])
+		str, eof, err := s.readFilter(string(bs[1:]), eof)
+		if err != nil {
+			return nil, eof, err
+		}
 		cmd := []string{"peek", str}
 		return cmd, eof, nil
+	} else if isKeyword(string(bs), 0, "def") {
+		str, isdef, eof, err := s.readDef(string(bs), eof)
+		if err != nil {
+			return nil, eof, err
+		}
+		cmd := []string{"def", str}
+		if !isdef {
+			cmd[0] = "push"
+		}
+		return cmd, eof, nil
 	} else if bs[0] != ':' {
-		str := string(bs)
+		str, eof, err := s.readFilter(string(bs), eof)
+		if err != nil {
+			return nil, eof, err
+		}
 		cmd := []string{"push", str}
 		return cmd, eof, nil
 	}
 
-	bs = bs[1:]
-	plusi := bytes.Index(bs, []byte{'+'})
-	var last *[]byte
-	if plusi > 0 {
-		lastp := bs[plusi+1:]
-		last = &lastp
-		bs = bs[:plusi]
+	cmd, err = tokenizeCommand(string(bs[1:]), 1)
+	if err != nil {
+		return nil, eof, err
 	}
-	cmd = strings.Fields(string(bs))
-	if last != nil {
-		cmd = append(cmd, string(*last))
+	if n := len(cmd); n > 1 && strings.HasPrefix(cmd[n-1], "<<") {
+		term := strings.TrimPrefix(cmd[n-1], "<<")
+		if term == "" {
+			term = defaultBlockTerminator
+		}
+		if eof {
+			msg := fmt.Sprintf("block is missing terminator %q", term)
+			return nil, eof, InvalidCommandError{Message: msg}
+		}
+		block, eof, err := s.readBlock(term)
+		if err != nil {
+			return nil, eof, err
+		}
+		cmd[n-1] = block
+		return cmd, eof, nil
 	}
 	return cmd, eof, nil
 }
 
-// An InitShellReader works like a SimpleShellReader but runs an init script
-// before reading any input.
+// cmdToken is a word in a command line.  start and end are the byte offsets of
+// the word's raw text in the line.
+type cmdToken struct {
+	text       string
+	start, end int
+}
+
+// tokenizeCommand splits line into words using shell-like quoting rules.
+// Words are separated by whitespace.  Text in single quotes is literal.  In
+// double quotes and in unquoted text a backslash escapes the following
+// character.  A word starting with '+' extends to the end of the line and is
+// taken literally, unless the '+' stands alone.  The filter given to a filter
+// command may also be the rest of the line (see joinFilterArg).  Errors are
+// InvalidCommandErrors with columns offset by col, the column preceding the
+// first rune of line.
+func tokenizeCommand(line string, col int) ([]string, error) {
+	toks, err := splitCommand(line, col)
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	for _, tok := range joinFilterArg(line, toks) {
+		words = append(words, tok.text)
+	}
+	return words, nil
+}
+
+// filterCommands take jq program text as their only argument after flags.
+// The value is true if the words of the argument are always joined, as for a
+// :def definition, rather than only when they are parts of one filter.
+var filterCommands = map[string]bool{"push": false, "peek": false, "def": true}
+
+// joinFilterArg replaces the words following the flags of a filter command
+// with the text of line they span if the words are parts of one filter (see
+// isPartialFilter), so a filter like ".a + .b" need not be quoted and the
+// quotes of its strings are kept.  Otherwise each word is a filter.
+func joinFilterArg(line string, toks []cmdToken) []cmdToken {
+	if len(toks) < 3 {
+		return toks
+	}
+	always, ok := filterCommands[toks[0].text]
+	if !ok {
+		return toks
+	}
+	n := 1
+	for n < len(toks) && isFlagWord(toks[n].text) {
+		n++
+		if toks[n-1].text == "--" {
+			break
+		}
+	}
+	if len(toks)-n < 2 {
+		return toks
+	}
+	partial := always
+	for _, tok := range toks[n:] {
+		partial = partial || isPartialFilter(line[tok.start:tok.end])
+	}
+	if !partial {
+		return toks
+	}
+	start, end := toks[n].start, toks[len(toks)-1].end
+	return append(toks[:n], cmdToken{line[start:end], start, end})
+}
+
+// isFlagWord returns true if word is a flag, like "-q", rather than a filter.
+func isFlagWord(word string) bool {
+	return len(word) > 1 && word[0] == '-' && (word[1] == '-' || unicode.IsLetter(rune(word[1])))
+}
+
+// isPartialFilter returns true if word, the text of a command word before
+// unquoting, cannot be a filter by itself because it is or ends with a binary
+// operator or contains unclosed brackets, parentheses, braces or strings.
+func isPartialFilter(word string) bool {
+	switch word {
+	case "-", "and", "or", "as", "?//":
+		return true
+	}
+	return filterIncomplete(word) ||
+		strings.ContainsAny(word[:1], "|+*/%=<>!,") ||
+		strings.ContainsAny(word[len(word)-1:], "|+-*/%=<>,")
+}
+
+func splitCommand(line string, col int) ([]cmdToken, error) {
+	var toks []cmdToken
+	var buf []rune
+	start := -1
+	errorf := func(pos int, format string, v ...interface{}) error {
+		return InvalidCommandError{
+			Message: fmt.Sprintf(format, v...),
+			Column:  col + utf8.RuneCountInString(line[:pos]) + 1,
+		}
+	}
+	for i := 0; i < len(line); {
+		c, n := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case unicode.IsSpace(c):
+			if start >= 0 {
+				toks = append(toks, cmdToken{string(buf), start, i})
+				buf = buf[:0]
+				start = -1
+			}
+			i += n
+			continue
+		case c == '+' && start < 0 && i+n < len(line) && !unicode.IsSpace(rune(line[i+n])):
+			toks = append(toks, cmdToken{line[i+n:], i + n, len(line)})
+			return toks, nil
+		}
+		if start < 0 {
+			start = i
+		}
+		switch c {
+		case '\\':
+			if i+n >= len(line) {
+				return nil, errorf(i, "trailing backslash")
+			}
+			c, m := utf8.DecodeRuneInString(line[i+n:])
+			buf = append(buf, c)
+			i += n + m
+		case '\'':
+			j := strings.IndexRune(line[i+n:], '\'')
+			if j < 0 {
+				return nil, errorf(i, "unterminated single quote")
+			}
+			buf = append(buf, []rune(line[i+n:i+n+j])...)
+			i += n + j + 1
+		case '"':
+			q := i
+			i += n
+			for {
+				if i >= len(line) {
+					return nil, errorf(q, "unterminated double quote")
+				}
+				c, n = utf8.DecodeRuneInString(line[i:])
+				i += n
+				if c == '"' {
+					break
+				}
+				if c == '\\' && i < len(line) {
+					c, n = utf8.DecodeRuneInString(line[i:])
+					i += n
+				}
+				buf = append(buf, c)
+			}
+		default:
+			buf = append(buf, c)
+			i += n
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, cmdToken{string(buf), start, len(line)})
+	}
+	return toks, nil
+}
+
+// defaultBlockTerminator ends a block started with "<<" when no terminator is
+// given.
+const defaultBlockTerminator = "EOF"
+
+// filterIncomplete returns true if filter contains unclosed brackets,
+// parentheses, braces or strings, or if it ends with a pipe.  Such a filter
+// must be continued on the next line.
+func filterIncomplete(filter string) bool {
+	// the stack holds the closing runes for open brackets.  a string
+	// interpolation "\(...)" is closed by ')' and then returns to the string.
+	var stack []byte
+	instring := false
+	for i := 0; i < len(filter); i++ {
+		c := filter[i]
+		if instring {
+			switch c {
+			case '\\':
+				if i+1 < len(filter) && filter[i+1] == '(' {
+					stack = append(stack, '"')
+					instring = false
+				}
+				i++
+			case '"':
+				instring = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			instring = true
+		case '#':
+			for i < len(filter) && filter[i] != '\n' {
+				i++
+			}
+		case '(':
+			stack = append(stack, ')')
+		case '[':
+			stack = append(stack, ']')
+		case '{':
+			stack = append(stack, '}')
+		case ')', ']', '}':
+			n := len(stack)
+			if n == 0 {
+				// unbalanced. jq will report the error.
+				return false
+			}
+			if stack[n-1] == '"' && c == ')' {
+				instring = true
+			}
+			stack = stack[:n-1]
+		}
+	}
+	if instring || len(stack) > 0 {
+		return true
+	}
+	return strings.HasSuffix(strings.TrimRightFunc(stripComment(filter), unicode.IsSpace), "|")
+}
+
+// onlyComments returns true if text contains nothing but whitespace and
+// comments.
+func onlyComments(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// stripComment removes a trailing comment from the last line of filter.
+func stripComment(filter string) string {
+	i := strings.LastIndex(filter, "\n")
+	j := strings.Index(filter[i+1:], "#")
+	if j < 0 || strings.Contains(filter[i+1:i+1+j], "\"") {
+		return filter
+	}
+	return filter[:i+1+j]
+}
+
+// splitScript splits a one line script into its commands.  Commands are
+// separated by semicolons that are not quoted, escaped with a backslash or
+// enclosed in brackets, parentheses or braces.  Surrounding whitespace is
+// removed and empty commands are dropped.
+func splitScript(script string) []string {
+	var cmds []string
+	var depth int
+	var quote byte
+	start := 0
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case (c == ')' || c == ']' || c == '}') && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			cmds = appendCommand(cmds, script[start:i])
+			start = i + 1
+		}
+	}
+	return appendCommand(cmds, script[start:])
+}
+
+func appendCommand(cmds []string, cmd string) []string {
+	cmd = strings.TrimSpace(cmd)
+	if cmd == "" {
+		return cmds
+	}
+	return append(cmds, cmd)
+}
+
+// An InitShellReader works like a SimpleShellReader but runs init scripts and
+// commands before reading any input.
 type InitShellReader struct {
-	i    int
-	init [][]string
-	r    *SimpleShellReader
+	i       int
+	init    [][]string
+	scripts []*SimpleShellReader
+	ended   bool // the first script has no more commands
+	inited  bool // all scripts and init commands have been read
+	r       *SimpleShellReader
 }
 
+var _ Positioned = (*InitShellReader)(nil)
+var _ Interruptible = (*InitShellReader)(nil)
+
 func NewInitShellReader(r io.Reader, prompt string, initcmds [][]string) *InitShellReader {
-	return &InitShellReader{0, initcmds, NewShellReader(r, prompt)}
+	return &InitShellReader{init: initcmds, r: NewShellReader(r, prompt)}
+}
+
+// AddScript adds a script read from r to be run before the init commands.
+// Scripts run in the order they are added.  Errors in the script are reported
+// with name and a line number.
+func (sh *InitShellReader) AddScript(name string, r io.Reader) {
+	s := NewShellReader(r, "")
+	s.SetName(name)
+	sh.scripts = append(sh.scripts, s)
 }
 
 func (sh *InitShellReader) Documentation() string {
 	return simpleShellReaderDocs
 }
 
+func (sh *InitShellReader) SetName(name string) {
+	sh.r.SetName(name)
+}
+
+func (sh *InitShellReader) SetPrompt(prompt string) {
+	sh.r.SetPrompt(prompt)
+}
+
+func (sh *InitShellReader) SetHistoryFile(path string) error {
+	return sh.r.SetHistoryFile(path)
+}
+
+func (sh *InitShellReader) SetCompleter(c Completer) {
+	sh.r.SetCompleter(c)
+}
+
+func (sh *InitShellReader) Interrupt() {
+	sh.r.Interrupt()
+}
+
+func (sh *InitShellReader) EditLine(prompt, text string) (string, error) {
+	return sh.r.EditLine(prompt, text)
+}
+
+// Position returns the position of the last command read from a script or
+// from the shell's input.  Init commands have no position.
+func (sh *InitShellReader) Position() string {
+	switch {
+	case len(sh.scripts) > 0:
+		return sh.scripts[0].Position()
+	case !sh.inited:
+		return ""
+	}
+	return sh.r.Position()
+}
+
 func (sh *InitShellReader) ReadCommand() ([]string, bool, error) {
 	if sh == nil {
 		panic("nil shell")
 	}
+	for len(sh.scripts) > 0 {
+		// a script's position is needed until its last command has run, so
+		// the script is removed on the following call.
+		if sh.ended {
+			sh.scripts = sh.scripts[1:]
+			sh.ended = false
+			continue
+		}
+		cmd, eof, err := sh.scripts[0].ReadCommand()
+		if err == io.EOF {
+			err = nil
+		}
+		sh.ended = eof
+		if eof && err == nil && len(cmd) == 0 {
+			continue
+		}
+		// the end of a script is not the end of the shell's input.
+		return cmd, false, err
+	}
 	if sh.i < len(sh.init) {
 		cmd := sh.init[sh.i]
 		sh.i++
 		return cmd, false, nil
 	}
+	sh.inited = true
 	return sh.r.ReadCommand()
 }
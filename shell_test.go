@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
@@ -87,6 +89,10 @@ func TestShellReaderReadCommand_single(t *testing.T) {
 		{".", cmd("write")},
 		{"..", cmd("pop")},
 		{"\n..", cmd("pop")},
+		{":peek '.a + .b'", cmd("peek", ".a + .b")},
+		{":load \"my file.json\"", cmd("load", "my file.json")},
+		{":load my\\ file.json", cmd("load", "my file.json")},
+		{":pipe -out +grep -v 'a b'", cmd("pipe", "-out", "grep -v 'a b'")},
 	} {
 		sh := StringShellReader(test.str)
 		sh.SetOutput(ioutil.Discard)
@@ -100,3 +106,236 @@ func TestShellReaderReadCommand_single(t *testing.T) {
 		}
 	}
 }
+
+func TestShellReaderReadCommand_multiline(t *testing.T) {
+	cmd := func(strs ...string) []string { return strs }
+	for i, test := range []struct {
+		str string
+		cmd []string
+	}{
+		{".items[] |\n.name\n", cmd("push", ".items[] |\n.name")},
+		{"{a: .x,\nb: .y}\n.z", cmd("push", "{a: .x,\nb: .y}")},
+		{"select(.a\n| .b)", cmd("push", "select(.a\n| .b)")},
+		{"?[.a,\n.b]", cmd("peek", "[.a,\n.b]")},
+		{"\"(\"", cmd("push", "\"(\"")},
+		{".a | # comment (\n.b", cmd("push", ".a | # comment (\n.b")},
+		{"\"\\(.a\n)\"", cmd("push", "\"\\(.a\n)\"")},
+		{".a |", cmd("push", ".a |")},
+		{":push <<\n.a |\n.b\nEOF\n:pop", cmd("push", ".a |\n.b")},
+		{":push -q <<END\n\n.a\n  END\n", cmd("push", "-q", "\n.a")},
+		{"def f: .a;\n.b", cmd("def", "def f: .a;")},
+		{"def f(g):\n  g | .a;\n.b", cmd("def", "def f(g):\n  g | .a;")},
+		{"define", cmd("push", "define")},
+		{"def f: .a; f", cmd("push", "def f: .a; f")},
+		{"def f: .a; f |\n.b\n.c", cmd("push", "def f: .a; f |\n.b")},
+		{"def f:\n.a; f\n.b", cmd("push", "def f:\n.a; f")},
+		{"def f: .a; # comment\n.b", cmd("def", "def f: .a; # comment")},
+		{"def f: .a;\ndef g: .b;", cmd("def", "def f: .a;")},
+	} {
+		sh := StringShellReader(test.str)
+		sh.SetOutput(ioutil.Discard)
+		cmd, _, err := sh.ReadCommand()
+		if err != nil {
+			t.Errorf("command %d (%q) %v", i, test.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd, test.cmd) {
+			t.Errorf("command %d (%q) got %q (expect %q)", i, test.str, cmd, test.cmd)
+		}
+	}
+
+	sh := StringShellReader(":push <<END\n.a\n")
+	sh.SetOutput(ioutil.Discard)
+	_, _, err := sh.ReadCommand()
+	if _, ok := err.(InvalidCommandError); !ok {
+		t.Errorf("unterminated block returned %v", err)
+	}
+}
+
+func TestShellReaderInterrupt(t *testing.T) {
+	var out bytes.Buffer
+	sh := NewShellReader(strings.NewReader(".a\n"), "> ")
+	sh.SetOutput(&out)
+	sh.Interrupt()
+	if out.Len() != 0 {
+		t.Errorf("interrupted before reading: %q", out.String())
+	}
+	_, _, err := sh.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.Interrupt()
+	if out.String() != "> \n> " {
+		t.Errorf("got %q (expect %q)", out.String(), "> \n> ")
+	}
+}
+
+func TestTokenizeCommand(t *testing.T) {
+	cmd := func(strs ...string) []string { return strs }
+	for i, test := range []struct {
+		str string
+		cmd []string
+		col int // column of an expected error
+	}{
+		{"", nil, 0},
+		{"  a  b ", cmd("a", "b"), 0},
+		{"a 'b c' d", cmd("a", "b c", "d"), 0},
+		{`a "b \"c\" \\" d`, cmd("a", `b "c" \`, "d"), 0},
+		{`a b\ c`, cmd("a", "b c"), 0},
+		{`a 'b'"c"d`, cmd("a", "bcd"), 0},
+		{`a '' b`, cmd("a", "", "b"), 0},
+		{`a '\n'`, cmd("a", `\n`), 0},
+		{"a +b 'c' +d", cmd("a", "b 'c' +d"), 0},
+		{"a b+c", cmd("a", "b+c"), 0},
+		{"peek .x + .y", cmd("peek", ".x + .y"), 0},
+		{"push .x .y", cmd("push", ".x", ".y"), 0},
+		{"push .x, .y", cmd("push", ".x, .y"), 0},
+		{"push .x and .y", cmd("push", ".x and .y"), 0},
+		{"push map(. * 2)", cmd("push", "map(. * 2)"), 0},
+		{"def inc(f): f + 1;", cmd("def", "inc(f): f + 1;"), 0},
+		{`def -q f: "a b";`, cmd("def", "-q", `f: "a b";`), 0},
+		{"def -f 'my defs.jq'", cmd("def", "-f", "my defs.jq"), 0},
+		{`push -q select(.x == "a b") | .y `, cmd("push", "-q", `select(.x == "a b") | .y`), 0},
+		{"push -- -.x + 1", cmd("push", "--", "-.x + 1"), 0},
+		{"peek '.x + .y'", cmd("peek", ".x + .y"), 0},
+		{`push "a b"`, cmd("push", "a b"), 0},
+		{"é 'b", nil, 3},
+		{`a "b`, nil, 3},
+		{`a b\`, nil, 4},
+	} {
+		cmd, err := tokenizeCommand(test.str, 0)
+		if test.col > 0 {
+			ierr, ok := err.(InvalidCommandError)
+			if !ok {
+				t.Errorf("command %d (%q) unexpected error: %v", i, test.str, err)
+			} else if ierr.Column != test.col {
+				t.Errorf("command %d (%q) error column %d (expect %d)", i, test.str, ierr.Column, test.col)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("command %d (%q) %v", i, test.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd, test.cmd) {
+			t.Errorf("command %d (%q) got %q (expect %q)", i, test.str, cmd, test.cmd)
+		}
+	}
+}
+
+func TestPeekFilterWords(t *testing.T) {
+	f, err := ioutil.TempFile("", "jqsh-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, `{"a": 1, "b": 2, "c": "x y"}`)
+	f.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	script := ":load " + f.Name() + "\n:peek .a + .b\n:peek .c == \"x y\"\n"
+	jq := NewScriptJQShell(new(GoEngine), StringShellReader(script), false)
+	err = jq.Wait()
+	w.Close()
+	os.Stdout = stdout
+	out, _ := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "3\ntrue\n"
+	if string(out) != expect {
+		t.Errorf("got %q (expect %q)", out, expect)
+	}
+}
+
+func TestDefCommand(t *testing.T) {
+	f, err := ioutil.TempFile("", "jqsh-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, `{"a": 1}`)
+	f.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	script := ":load " + f.Name() + "\n:def inc(f): f + 1;\n:def def twice: . * 2;\n:peek inc(.a) | twice\n"
+	jq := NewScriptJQShell(new(GoEngine), StringShellReader(script), false)
+	err = jq.Wait()
+	w.Close()
+	os.Stdout = stdout
+	out, _ := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "4\n" {
+		t.Errorf("got %q (expect %q)", out, "4\n")
+	}
+}
+
+func TestSplitScript(t *testing.T) {
+	cmds := func(strs ...string) []string { return strs }
+	for i, test := range []struct {
+		str  string
+		cmds []string
+	}{
+		{"", nil},
+		{" ; ;", nil},
+		{":push .a; :write out.json", cmds(":push .a", ":write out.json")},
+		{".a;.b;", cmds(".a", ".b")},
+		{"reduce .[] as $x (0; . + $x); .", cmds("reduce .[] as $x (0; . + $x)", ".")},
+		{`:push '.a;.b'; :push ".c;\".d"`, cmds(`:push '.a;.b'`, `:push ".c;\".d"`)},
+		{`:push a\;b`, cmds(`:push a\;b`)},
+	} {
+		cmds := splitScript(test.str)
+		if !reflect.DeepEqual(cmds, test.cmds) {
+			t.Errorf("script %d (%q) got %q (expect %q)", i, test.str, cmds, test.cmds)
+		}
+	}
+}
+
+func TestInitShellReaderScripts(t *testing.T) {
+	sh := NewInitShellReader(strings.NewReader(":input\n"), "", [][]string{{"init"}})
+	sh.AddScript("a", strings.NewReader("# comment\n:a1\n\n:a2 <<\nx\nEOF\n"))
+	sh.AddScript("b", strings.NewReader(":b1"))
+	sh.AddScript("c", strings.NewReader(""))
+	for i, test := range []struct {
+		cmd []string
+		pos string
+		eof bool
+	}{
+		{[]string{"a1"}, "a:2", false},
+		{[]string{"a2", "x"}, "a:4", false},
+		{[]string{"b1"}, "b:1", false},
+		{[]string{"init"}, "", false},
+		{[]string{"input"}, "", false},
+		{nil, "", true},
+	} {
+		cmd, eof, err := sh.ReadCommand()
+		if err != nil && err != io.EOF {
+			t.Fatalf("command %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(cmd, test.cmd) {
+			t.Errorf("command %d: got %q (expect %q)", i, cmd, test.cmd)
+		}
+		if pos := sh.Position(); pos != test.pos {
+			t.Errorf("command %d: position %q (expect %q)", i, pos, test.pos)
+		}
+		if eof != test.eof {
+			t.Errorf("command %d: eof %v (expect %v)", i, eof, test.eof)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// stream.go
+// exploring input too large to parse at once with jq --stream
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// streamEvents returns the number of filters at the bottom of the stack which
+// are applied to streaming events instead of whole values.  Events become
+// values again at the first filter which calls fromstream, as pushed by
+// :fromstream.
+func (jq *JQShell) streamEvents() int {
+	if !jq.Options.Stream {
+		return 0
+	}
+	filters := jq.Stack.Filters()
+	for i, f := range filters {
+		if strings.HasPrefix(JoinFilter(f), "fromstream(") {
+			return i + 1
+		}
+	}
+	return len(filters)
+}
+
+// parseStreamPath parses a path like ".items[0].name" or `."a b"` into its
+// keys and indices.  Only paths with literal keys and indices are supported.
+func parseStreamPath(s string) ([]interface{}, error) {
+	path := []interface{}{}
+	rest := strings.TrimSpace(s)
+	if rest == "." {
+		return path, nil
+	}
+	invalid := fmt.Errorf("invalid path %q", s)
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, `."`) || strings.HasPrefix(rest, `["`):
+			bracket := rest[0] == '['
+			var key string
+			dec := json.NewDecoder(strings.NewReader(rest[1:]))
+			err := dec.Decode(&key)
+			if err != nil {
+				return nil, invalid
+			}
+			rest = rest[1+int(dec.InputOffset()):]
+			if bracket {
+				if !strings.HasPrefix(rest, "]") {
+					return nil, invalid
+				}
+				rest = rest[1:]
+			}
+			path = append(path, key)
+		case strings.HasPrefix(rest, "."):
+			n := strings.IndexFunc(rest[1:], func(c rune) bool {
+				return !(c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c))
+			})
+			if n < 0 {
+				n = len(rest) - 1
+			}
+			if n == 0 {
+				// allow ".[0]"
+				if strings.HasPrefix(rest, ".[") {
+					rest = rest[1:]
+					continue
+				}
+				return nil, invalid
+			}
+			path = append(path, rest[1:1+n])
+			rest = rest[1+n:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, invalid
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, invalid
+			}
+			path = append(path, i)
+			rest = rest[end+1:]
+		default:
+			return nil, invalid
+		}
+	}
+	return path, nil
+}
+
+// streamSelect returns a filter which selects the streaming events under the
+// value at prefix and removes the first n elements of their paths.  Events for
+// scalars at depth n are kept so that they become whole values, unlike jq's
+// truncate_stream.
+func streamSelect(prefix []interface{}, n int) string {
+	var conds []string
+	if len(prefix) > 0 {
+		bs, _ := json.Marshal(prefix)
+		conds = append(conds, fmt.Sprintf(".[0][:%d] == %s", len(prefix), bs))
+	}
+	if n > 0 {
+		cond := fmt.Sprintf("(.[0] | length) > %d or length == 2 and (.[0] | length) == %d", n, n)
+		if len(conds) > 0 {
+			cond = "(" + cond + ")"
+		}
+		conds = append(conds, cond)
+	}
+	var filters []string
+	if len(conds) > 0 {
+		filters = append(filters, "select("+strings.Join(conds, " and ")+")")
+	}
+	if n > 0 {
+		filters = append(filters, fmt.Sprintf(".[0] |= .[%d:]", n))
+	}
+	return strings.Join(filters, " | ")
+}
+
+// streamCommandArgs parses the arguments common to :fromstream and
+// :truncate and returns the selected prefix and the number of path elements to
+// remove.
+func streamCommandArgs(jq *JQShell, args []string, depth int) ([]interface{}, int, error) {
+	if !jq.Options.Stream {
+		return nil, 0, fmt.Errorf("input is not streamed (see :load -stream)")
+	}
+	if jq.streamEvents() < jq.Stack.Len() {
+		return nil, 0, fmt.Errorf("the filter stack already contains fromstream")
+	}
+	if len(args) > 1 {
+		return nil, 0, fmt.Errorf("expects one path")
+	}
+	if depth < 0 {
+		return nil, 0, fmt.Errorf("negative depth")
+	}
+	prefix := []interface{}{}
+	if len(args) == 1 {
+		var err error
+		prefix, err = parseStreamPath(args[0])
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return prefix, len(prefix) + depth, nil
+}
+
+func cmdFromstream(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command fromstream pushes a filter turning streaming events back into values.")
+	flags.ArgSet("[path]")
+	flags.ArgDoc("path", "the path of the value to rebuild, like .items or .a[0] (default .)")
+	depth := flags.Int("depth", 1, "rebuild the values this many levels below path")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after push")
+	flags.Docs(
+		"With input loaded by :load -stream filters are applied to [path, leaf] events.",
+		"The pushed filter rebuilds each value at the given depth below path,",
+		"so :fromstream .items outputs each element of the items array.",
+		"Only the values selected need to fit in memory.",
+		"Filters already on the stack are moved inside the pushed filter",
+		"because fromstream must see every event.",
+		"Filters pushed after fromstream are applied to whole values.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	prefix, n, err := streamCommandArgs(jq, flags.Args(), *depth)
+	if err != nil {
+		return err
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		filter := fromstreamFilter(s.Filters(), prefix, n)
+		s.PopAll()
+		s.Push(FilterString(filter))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+// fromstreamFilter returns a filter applying the event filters to every
+// streaming event and rebuilding the values selected by prefix and n (see
+// streamSelect).  Events are read with inputs because fromstream must see all
+// of them, so the event filters cannot remain below it in the stack.
+func fromstreamFilter(events []Filter, prefix []interface{}, n int) string {
+	filters := []string{"(., inputs)"}
+	for _, f := range events {
+		filters = append(filters, JoinFilter(f))
+	}
+	if sel := streamSelect(prefix, n); sel != "" {
+		filters = append(filters, sel)
+	}
+	if len(filters) == 1 {
+		return "fromstream(., inputs)"
+	}
+	return "fromstream(" + strings.Join(filters, FilterJoinString) + ")"
+}
+
+func cmdTruncate(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command truncate pushes a filter selecting the streaming events under a path.")
+	flags.ArgSet("path")
+	flags.ArgDoc("path", "the path of a value, like .items or .a[0]")
+	depth := flags.Int("depth", 0, "also remove this many levels below path from event paths")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after push")
+	flags.Docs(
+		"The pushed filter removes path from the paths of the events under it,",
+		"like jq's truncate_stream.  The filters above still see streaming events.",
+	)
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects one path")
+	}
+	prefix, n, err := streamCommandArgs(jq, flags.Args(), *depth)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("nothing to truncate")
+	}
+	err = jq.modifyStack(func(s *JQStack) error {
+		s.Push(FilterString(streamSelect(prefix, n)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
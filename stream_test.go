@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStreamPath(t *testing.T) {
+	for i, test := range []struct {
+		path   string
+		expect []interface{}
+	}{
+		{".", []interface{}{}},
+		{".items", []interface{}{"items"}},
+		{".items[0].name", []interface{}{"items", 0, "name"}},
+		{`."a b".c`, []interface{}{"a b", "c"}},
+		{`.[1]["x"]`, []interface{}{1, "x"}},
+		{".items[]", nil},
+		{".a.", nil},
+		{"items", nil},
+		{".a[-1]", nil},
+	} {
+		path, err := parseStreamPath(test.path)
+		if test.expect == nil {
+			if err == nil {
+				t.Errorf("test %d (%q): expected an error", i, test.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d (%q): %v", i, test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(path, test.expect) {
+			t.Errorf("test %d (%q): got %#v (expect %#v)", i, test.path, path, test.expect)
+		}
+	}
+}
+
+// streamTestInput is streamed by TestGoStreamIter and TestStreamFilters.
+const streamTestInput = `{"a":1,"b":[2,{}],"c":[],"items":[{"x":1},{"x":[2]},3]} 4 []`
+
+func TestGoStreamIter(t *testing.T) {
+	expect := []string{
+		`[["a"],1]`,
+		`[["b",0],2]`,
+		`[["b",1],{}]`,
+		`[["b",1]]`,
+		`[["c"],[]]`,
+		`[["items",0,"x"],1]`,
+		`[["items",0,"x"]]`,
+		`[["items",1,"x",0],2]`,
+		`[["items",1,"x",0]]`,
+		`[["items",1,"x"]]`,
+		`[["items",2],3]`,
+		`[["items",2]]`,
+		`[["items"]]`,
+		`[[],4]`,
+		`[[],[]]`,
+	}
+	s := new(JQStack)
+	var out, errbuf bytes.Buffer
+	opts := &JQOptions{Compact: true, Stream: true}
+	_, _, err := new(GoEngine).Execute(&out, &errbuf, strings.NewReader(streamTestInput), nil, false, opts, s)
+	if err != nil {
+		t.Fatalf("%v (%s)", err, errbuf.Bytes())
+	}
+	events := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !reflect.DeepEqual(events, expect) {
+		t.Errorf("got %q (expect %q)", events, expect)
+	}
+
+	out.Reset()
+	_, _, err = new(GoEngine).Execute(&out, &errbuf, strings.NewReader(`{"a":[1,`), nil, false, opts, s)
+	if err == nil {
+		t.Errorf("truncated input: expected an error")
+	}
+}
+
+func TestStreamFilters(t *testing.T) {
+	jq := &JQShell{
+		Stack:   new(JQStack),
+		Options: DefaultJQOptions(),
+	}
+	jq.Options.Stream = true
+	for i, test := range []struct {
+		cmd    string
+		events []Filter // event filters below fromstream
+		path   string
+		depth  int
+		expect string
+	}{
+		{"fromstream", nil, ".items", 1, `{"x":1} {"x":[2]} 3`},
+		{"fromstream", nil, ".items", 0, `[{"x":1},{"x":[2]},3]`},
+		{"fromstream", nil, ".", 1, `1 [2,{}] [] [{"x":1},{"x":[2]},3]`},
+		{"fromstream", nil, ".", 0, `{"a":1,"b":[2,{}],"c":[],"items":[{"x":1},{"x":[2]},3]} 4 []`},
+		{"fromstream", nil, ".items[1]", 1, `[2]`},
+		{"fromstream", []Filter{FilterString(streamSelect([]interface{}{"items"}, 1))}, ".", 1, `{"x":1} {"x":[2]} 3`},
+		{"truncate", nil, ".items", 1, `[["x"],1] [["x"]] [["x",0],2] [["x",0]] [["x"]] [[],3]`},
+	} {
+		prefix, n, err := streamCommandArgs(jq, []string{test.path}, test.depth)
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		filter := streamSelect(prefix, n)
+		if test.cmd == "fromstream" {
+			filter = fromstreamFilter(test.events, prefix, n)
+		}
+		s := new(JQStack)
+		s.Push(FilterString(filter))
+		var out, errbuf bytes.Buffer
+		opts := &JQOptions{Compact: true, Stream: true}
+		_, _, err = new(GoEngine).Execute(&out, &errbuf, strings.NewReader(streamTestInput), nil, false, opts, s)
+		if err != nil {
+			t.Errorf("test %d (%q): %v (%s)", i, filter, err, errbuf.Bytes())
+			continue
+		}
+		got := strings.Replace(strings.TrimSpace(out.String()), "\n", " ", -1)
+		if got != test.expect {
+			t.Errorf("test %d (%q): got %q (expect %q)", i, filter, got, test.expect)
+		}
+
+		jqbin, err := LocateJQ("")
+		if err != nil {
+			continue
+		}
+		out.Reset()
+		_, _, err = Execute(&out, &errbuf, strings.NewReader(streamTestInput), nil, jqbin, false, opts, s)
+		if err != nil {
+			t.Errorf("test %d (%q): jq: %v (%s)", i, filter, err, errbuf.Bytes())
+			continue
+		}
+		got = strings.Replace(strings.TrimSpace(out.String()), "\n", " ", -1)
+		if got != test.expect {
+			t.Errorf("test %d (%q): jq: got %q (expect %q)", i, filter, got, test.expect)
+		}
+	}
+}
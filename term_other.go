@@ -0,0 +1,28 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+// term_other.go
+// terminal stubs for systems without termios.  jqsh falls back to reading
+// plain lines on these systems.
+
+package main
+
+import "fmt"
+
+type termState struct{}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (*termState, error) {
+	return nil, fmt.Errorf("raw terminal mode is not supported")
+}
+
+func restoreTerm(fd uintptr, state *termState) error {
+	return nil
+}
+
+func terminalWidth(fd uintptr) int {
+	return 80
+}
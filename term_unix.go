@@ -0,0 +1,71 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+// term_unix.go
+// terminal mode handling for unix-like systems
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type termState struct {
+	termios syscall.Termios
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal returns true if fd is connected to a terminal.
+func isTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, ioctlReadTermios, unsafe.Pointer(&termios)) == nil
+}
+
+// makeRaw puts the terminal connected to fd into raw mode and returns its
+// previous state so that it may be restored with restoreTerm.  Output
+// processing is left enabled so newlines written to the terminal behave as
+// usual.
+func makeRaw(fd uintptr) (*termState, error) {
+	var old termState
+	err := ioctl(fd, ioctlReadTermios, unsafe.Pointer(&old.termios))
+	if err != nil {
+		return nil, err
+	}
+	raw := old.termios
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+func restoreTerm(fd uintptr, state *termState) error {
+	return ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&state.termios))
+}
+
+// terminalWidth returns the number of columns in the terminal connected to
+// fd.  If the width cannot be determined 80 is returned.
+func terminalWidth(fd uintptr) int {
+	var ws struct {
+		Row, Col, X, Y uint16
+	}
+	err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws))
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
@@ -0,0 +1,227 @@
+// vars.go
+// jq variables bound by the shell
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// The kinds of variable binding, named after the jq flag passing them.
+const (
+	VarString = "arg"       // a string
+	VarJSON   = "argjson"   // a JSON text
+	VarFile   = "slurpfile" // an array of the JSON values in a file
+)
+
+// JQVar is a variable available to filters as $Name.
+type JQVar struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Args returns the jq command line arguments binding v.
+func (v JQVar) Args() []string {
+	return []string{"--" + v.Kind, v.Name, v.Value}
+}
+
+// bindVars returns o without the variables bound to files which program does
+// not refer to, so that applying a filter does not parse files it does not
+// use.  Variables are referred to as $name or through $ARGS.
+func (o *JQOptions) bindVars(program string) *JQOptions {
+	if o == nil || refersToVar(program, "ARGS") {
+		return o
+	}
+	var vars []JQVar
+	for _, v := range o.Vars {
+		if v.Kind != VarFile || refersToVar(program, v.Name) {
+			vars = append(vars, v)
+		}
+	}
+	if len(vars) == len(o.Vars) {
+		return o
+	}
+	opts := *o
+	opts.Vars = vars
+	return &opts
+}
+
+// refersToVar returns true if program contains the variable $name.
+func refersToVar(program, name string) bool {
+	ref := "$" + name
+	for i := 0; i < len(program); {
+		j := strings.Index(program[i:], ref)
+		if j < 0 {
+			return false
+		}
+		end := i + j + len(ref)
+		if end == len(program) || !isWordRune(rune(program[end])) {
+			return true
+		}
+		i = end
+	}
+	return false
+}
+
+// SetVar binds a variable, replacing any existing variable with the same
+// name.
+func (o *JQOptions) SetVar(v JQVar) {
+	vars := make([]JQVar, 0, len(o.Vars)+1)
+	for _, w := range o.Vars {
+		if w.Name != v.Name {
+			vars = append(vars, w)
+		}
+	}
+	o.Vars = append(vars, v)
+}
+
+// UnsetVar removes the named variable binding.  UnsetVar returns false if
+// there was no such variable.
+func (o *JQOptions) UnsetVar(name string) bool {
+	vars := make([]JQVar, 0, len(o.Vars))
+	for _, v := range o.Vars {
+		if v.Name != name {
+			vars = append(vars, v)
+		}
+	}
+	ok := len(vars) < len(o.Vars)
+	o.Vars = vars
+	return ok
+}
+
+func (jq *JQShell) varNames() []string {
+	var names []string
+	for _, v := range jq.Options.Vars {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// varName returns the variable name in arg, which may begin with '$'.
+func varName(arg string) (string, error) {
+	name := strings.TrimPrefix(arg, "$")
+	if !isIdentifier(name) {
+		return "", fmt.Errorf("invalid variable name %q", arg)
+	}
+	return name, nil
+}
+
+// cmdLetKind returns a command binding variables of the given kind.
+func cmdLetKind(kind string) JQShellCommandFunc {
+	return func(jq *JQShell, flags *CmdFlags) error {
+		switch kind {
+		case VarString:
+			flags.About("Command let binds a variable to a string.")
+			flags.ArgSet("name", "value")
+			flags.ArgDoc("value", "a string")
+		case VarJSON:
+			flags.About("Command letjson binds a variable to a JSON value.")
+			flags.ArgSet("name", "json")
+			flags.ArgDoc("json", "a JSON text")
+		case VarFile:
+			flags.About("Command letfile binds a variable to an array of the values in a JSON file.")
+			flags.ArgSet("name", "file")
+			flags.ArgDoc("file", "a file containing JSON values")
+		}
+		flags.ArgDoc("name", "the variable name, referenced in filters as $name")
+		docs := []string{
+			"Variables are passed to every invocation of jq with the --" + kind + " flag",
+			"and are included in the output of :script.",
+		}
+		if kind == VarFile {
+			docs = []string{
+				"Variables are passed to jq with the --" + kind + " flag when a filter",
+				"refers to them, so the file is only parsed by filters which use it.",
+			}
+		}
+		flags.Docs(docs...)
+		quiet := flags.Bool("q", false, "quiet -- no implicit :write after binding")
+		err := flags.Parse(nil)
+		if IsHelp(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if flags.NArg() != 2 {
+			return fmt.Errorf("expects a name and a value")
+		}
+		name, err := varName(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		value := flags.Arg(1)
+		switch kind {
+		case VarJSON:
+			if !json.Valid([]byte(value)) {
+				return fmt.Errorf("invalid JSON value %q", value)
+			}
+		case VarFile:
+			_, err := os.Stat(value)
+			if err != nil {
+				return err
+			}
+		}
+		jq.Options.SetVar(JQVar{Name: name, Kind: kind, Value: value})
+		if !*quiet {
+			return jq.writeImplicit()
+		}
+		return nil
+	}
+}
+
+func cmdUnlet(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command unlet removes variable bindings.")
+	flags.ArgSet("variable", "...")
+	flags.ArgDoc("variable", "the name of a variable listed by :vars")
+	flags.Docs("Variables used by the filter stack cannot be removed.")
+	quiet := flags.Bool("q", false, "quiet -- no implicit :write after removing")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expects at least one variable")
+	}
+	saved := jq.Options.Vars
+	for _, arg := range flags.Args() {
+		name := strings.TrimPrefix(arg, "$")
+		if !jq.Options.UnsetVar(name) {
+			jq.Options.Vars = saved
+			return fmt.Errorf("unknown variable %q", arg)
+		}
+	}
+	err = testFilter(jq)
+	if err != nil {
+		jq.Options.Vars = saved
+		return fmt.Errorf("the filter stack uses the variables: %v", err)
+	}
+	if !*quiet {
+		return jq.writeImplicit()
+	}
+	return nil
+}
+
+func cmdVars(jq *JQShell, flags *CmdFlags) error {
+	flags.About("Command vars lists the variables bound with :let, :letjson and :letfile.")
+	err := flags.Parse(nil)
+	if IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 5, 4, 2, ' ', 0)
+	for _, v := range jq.Options.Vars {
+		fmt.Fprintf(tw, "$%s\t%s\t%s\n", v.Name, v.Kind, v.Value)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,118 @@
+// worker.go
+// an engine keeping parsed input in memory between commands
+
+package main
+
+import (
+	"io"
+)
+
+// WorkerEngine is a GoEngine which parses the shell's input once and keeps
+// the values in memory until the input changes.  Successive filters only pay
+// for their own evaluation, not for reading and parsing the input again.
+type WorkerEngine struct {
+	GoEngine
+}
+
+var _ Preparer = (*WorkerEngine)(nil)
+
+func (e *WorkerEngine) Prepare(in io.Reader, stop <-chan struct{}) (PreparedInput, error) {
+	p := &goPreparedInput{engine: &e.GoEngine}
+	iter := newGoInputIter(in)
+	for {
+		select {
+		case <-stop:
+			return nil, ErrInterrupted
+		default:
+		}
+		v, ok := iter.Next()
+		if !ok {
+			return p, nil
+		}
+		// like jq, values before invalid input are still filtered.
+		if err, ok := v.(error); ok {
+			p.err = err
+			return p, nil
+		}
+		p.values = append(p.values, v)
+	}
+}
+
+type goPreparedInput struct {
+	engine *GoEngine
+	values []interface{}
+	err    error // the error which ended parsing
+}
+
+func (p *goPreparedInput) Execute(outw, errw io.Writer, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	return p.engine.execute(outw, errw, &goValueIter{values: p.values, err: p.err}, stop, opts, s)
+}
+
+// goValueIter is a gojq.Iter over parsed values followed by err, if it is not
+// nil.
+type goValueIter struct {
+	values []interface{}
+	err    error
+}
+
+func (iter *goValueIter) Next() (interface{}, bool) {
+	if len(iter.values) == 0 {
+		if iter.err == nil {
+			return nil, false
+		}
+		err := iter.err
+		iter.err = nil
+		return err, true
+	}
+	v := iter.values[0]
+	iter.values = iter.values[1:]
+	return v, true
+}
+
+// executeInput applies the filter in s to the shell's input.  If the engine
+// is a Preparer the input is parsed the first time it is used and the parsed
+// values are used by later commands until the input changes.  Input produced
+// by a command is not prepared because it is produced each time it is read,
+// and streamed input is not prepared because it may not fit in memory.
+func (jq *JQShell) executeInput(outw, errw io.Writer, stop <-chan struct{}, color bool, opts *JQOptions, s *JQStack) (int64, int64, error) {
+	var p PreparedInput
+	var err error
+	if !opts.Stream {
+		p, err = jq.preparedInput(stop)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if p != nil {
+		return p.Execute(outw, errw, stop, color, opts, s)
+	}
+	r, err := jq.Input()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	return jq.engine.Execute(outw, errw, r, stop, color, opts, s)
+}
+
+// preparedInput returns the prepared input of the shell, preparing it if
+// necessary.  preparedInput returns nil if the input cannot be prepared.
+func (jq *JQShell) preparedInput(stop <-chan struct{}) (PreparedInput, error) {
+	e, ok := jq.engine.(Preparer)
+	if !ok || jq.filename == "" {
+		return nil, nil
+	}
+	if jq.prepared != nil {
+		return jq.prepared, nil
+	}
+	r, err := jq.Input()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	p, err := e.Prepare(r, stop)
+	if err != nil {
+		return nil, err
+	}
+	jq.prepared = p
+	return p, nil
+}
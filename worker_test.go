@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWorkerEngine(t *testing.T) {
+	in := `{"a": 1} {"a": 2} {"a": 3} {`
+	p, err := new(WorkerEngine).Prepare(strings.NewReader(in), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		filter string
+		opts   JQOptions
+	}{
+		{".a", JQOptions{}},
+		{".a + 1", JQOptions{Compact: true}},
+		{"map(.a) | add", JQOptions{Slurp: true}},
+		{"[inputs.a]", JQOptions{NullInput: true}},
+	} {
+		s := new(JQStack)
+		s.Push(FilterString(test.filter))
+
+		// the prepared input gives the same results every time it is used,
+		// and the same results as parsing the input again.
+		var expect, expecterr bytes.Buffer
+		_, _, experr := new(GoEngine).Execute(&expect, &expecterr, strings.NewReader(in), nil, false, &test.opts, s)
+		for j := 0; j < 2; j++ {
+			var out, errbuf bytes.Buffer
+			_, _, err := p.Execute(&out, &errbuf, nil, false, &test.opts, s)
+			if (err == nil) != (experr == nil) {
+				t.Errorf("test %d (%q): error %v (expect %v)", i, test.filter, err, experr)
+			}
+			if out.String() != expect.String() || errbuf.String() != expecterr.String() {
+				t.Errorf("test %d (%q): got %q %q (expect %q %q)", i, test.filter, out.String(), errbuf.String(), expect.String(), expecterr.String())
+			}
+		}
+	}
+}